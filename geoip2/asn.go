@@ -1,7 +1,5 @@
 package geoip2
 
-import "errors"
-
 func readASNMap(result *ASN, buffer []byte, mapSize, offset uint) (uint, error) {
 	var key []byte
 	var err error
@@ -22,7 +20,10 @@ func readASNMap(result *ASN, buffer []byte, mapSize, offset uint) (uint, error)
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown asn key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
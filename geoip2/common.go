@@ -4,19 +4,27 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
+	"math/big"
 	"strconv"
 )
 
 func readControl(buffer []byte, offset uint) (byte, uint, uint, error) {
+	if offset >= uint(len(buffer)) {
+		return 0, 0, 0, errors.New("invalid offset")
+	}
 	controlByte := buffer[offset]
 	offset++
 	dataType := controlByte >> 5
 	if dataType == dataTypeExtended {
+		if offset >= uint(len(buffer)) {
+			return 0, 0, 0, errors.New("invalid offset")
+		}
 		dataType = buffer[offset] + 7
 		offset++
 	}
 	size := uint(controlByte & 0x1f)
-	if dataType == dataTypeExtended || size < 29 {
+	// the size bits of a pointer are part of its value, see readPointer
+	if dataType == dataTypeExtended || dataType == dataTypePointer || size < 29 {
 		return dataType, size, offset, nil
 	}
 	bytesToRead := size - 28
@@ -294,6 +302,180 @@ func readMapKey(buffer []byte, offset uint) ([]byte, uint, error) {
 	return buffer[offset:newOffset], newOffset, nil
 }
 
+// skipValue returns the offset of the value that follows the one at offset, without decoding it, so the keys
+// unknown to the readers are ignored whatever the type of their value.
+func skipValue(buffer []byte, offset uint) (uint, error) {
+	dataType, size, offset, err := readControl(buffer, offset)
+	if err != nil {
+		return 0, err
+	}
+	switch dataType {
+	case dataTypePointer:
+		// the value pointed to is stored elsewhere, only the pointer is skipped
+		_, newOffset, err := readPointer(buffer, size, offset)
+		return newOffset, err
+	case dataTypeMap:
+		for i := uint(0); i < size; i++ {
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return offset, nil
+	case dataTypeSlice:
+		for i := uint(0); i < size; i++ {
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return offset, nil
+	case dataTypeBool, dataTypeDataCacheContainer, dataTypeEndMarker:
+		// no payload, the value of a bool is its size
+		return offset, nil
+	case dataTypeFloat64:
+		if size != 8 {
+			return 0, errors.New("invalid float64 size: " + strconv.Itoa(int(size)))
+		}
+	case dataTypeFloat32:
+		if size != 4 {
+			return 0, errors.New("invalid float32 size: " + strconv.Itoa(int(size)))
+		}
+	case dataTypeUint16, dataTypeUint32, dataTypeInt32, dataTypeUint64, dataTypeUint128:
+		if size > integerMaxSize(dataType) {
+			return 0, errors.New("invalid integer size: " + strconv.Itoa(int(size)))
+		}
+	}
+	// strings, bytes, numbers and the types of newer format versions: size bytes of payload
+	newOffset := offset + size
+	if newOffset > uint(len(buffer)) {
+		return 0, errors.New("invalid offset")
+	}
+	return newOffset, nil
+}
+
+// readValue decodes the value at offset of any type, maps and slices are decoded as
+// map[string]interface{} and []interface{}, uint16, uint32 and uint64 as uint64 and uint128 as *big.Int.
+func readValue(buffer []byte, offset uint) (interface{}, uint, error) {
+	return readValueDepth(buffer, offset, 0)
+}
+
+// readValueDepth decodes the value at offset, depth counts the maps, slices and pointers it is nested in, so the
+// pointer cycles of a corrupted DB fail instead of overflowing the stack.
+func readValueDepth(buffer []byte, offset, depth uint) (interface{}, uint, error) {
+	if depth > maxValueDepth {
+		return nil, 0, errors.New("exceeded maximum data structure depth")
+	}
+	dataType, size, offset, err := readControl(buffer, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch dataType {
+	case dataTypePointer:
+		pointer, newOffset, err := readPointer(buffer, size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		if pointer < uint(len(buffer)) && buffer[pointer]>>5 == dataTypePointer {
+			return nil, 0, errors.New("invalid pointer to pointer")
+		}
+		value, _, err := readValueDepth(buffer, pointer, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return value, newOffset, nil
+	case dataTypeMap:
+		// a key and a value take at least 2 bytes, the size can't claim more entries than the buffer holds
+		if size > (uint(len(buffer))-offset)/2 {
+			return nil, 0, errors.New("invalid map size: " + strconv.Itoa(int(size)))
+		}
+		var key []byte
+		var value interface{}
+		result := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, offset, err = readMapKey(buffer, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, offset, err = readValueDepth(buffer, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			result[bytesToKeyString(key)] = value
+		}
+		return result, offset, nil
+	case dataTypeSlice:
+		if size > uint(len(buffer))-offset {
+			return nil, 0, errors.New("invalid slice size: " + strconv.Itoa(int(size)))
+		}
+		var value interface{}
+		result := make([]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			value, offset, err = readValueDepth(buffer, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			result[i] = value
+		}
+		return result, offset, nil
+	case dataTypeBool:
+		return size != 0, offset, nil
+	case dataTypeDataCacheContainer, dataTypeEndMarker:
+		return nil, 0, errors.New("invalid value type: " + strconv.Itoa(int(dataType)))
+	case dataTypeUint16, dataTypeUint32, dataTypeInt32, dataTypeUint64, dataTypeUint128:
+		if size > integerMaxSize(dataType) {
+			return nil, 0, errors.New("invalid integer size: " + strconv.Itoa(int(size)))
+		}
+	}
+	newOffset := offset + size
+	if newOffset > uint(len(buffer)) {
+		return nil, 0, errors.New("invalid offset")
+	}
+	value := buffer[offset:newOffset]
+	switch dataType {
+	case dataTypeString:
+		return bytesToString(value), newOffset, nil
+	case dataTypeFloat64:
+		if size != 8 {
+			return nil, 0, errors.New("invalid float64 size: " + strconv.Itoa(int(size)))
+		}
+		return bytesToFloat64(value), newOffset, nil
+	case dataTypeFloat32:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float32 size: " + strconv.Itoa(int(size)))
+		}
+		return bytesToFloat32(value), newOffset, nil
+	case dataTypeBytes:
+		return append([]byte(nil), value...), newOffset, nil
+	case dataTypeUint16, dataTypeUint32, dataTypeUint64:
+		return bytesToUInt64(value), newOffset, nil
+	case dataTypeInt32:
+		return int32(uint32(bytesToUInt64(value))), newOffset, nil
+	case dataTypeUint128:
+		return new(big.Int).SetBytes(value), newOffset, nil
+	default:
+		return nil, 0, errors.New("invalid value type: " + strconv.Itoa(int(dataType)))
+	}
+}
+
+// integerMaxSize returns the number of bytes an integer type holds at most, e.g. 2 for a uint16.
+func integerMaxSize(dataType byte) uint {
+	switch dataType {
+	case dataTypeUint16:
+		return 2
+	case dataTypeUint32, dataTypeInt32:
+		return 4
+	case dataTypeUint64:
+		return 8
+	default:
+		return 16
+	}
+}
+
 func readStringSlice(buffer []byte, sliceSize, offset uint) ([]string, uint, error) {
 	var err error
 	var value string
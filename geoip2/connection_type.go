@@ -1,7 +1,5 @@
 package geoip2
 
-import "errors"
-
 func readConnectionTypeMap(result *ConnectionType, buffer []byte, mapSize, offset uint) (uint, error) {
 	var key []byte
 	var err error
@@ -17,7 +15,10 @@ func readConnectionTypeMap(result *ConnectionType, buffer []byte, mapSize, offse
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown connectionType key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
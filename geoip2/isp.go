@@ -1,7 +1,5 @@
 package geoip2
 
-import "errors"
-
 func readISPMap(result *ISP, buffer []byte, mapSize, offset uint) (uint, error) {
 	var key []byte
 	var err error
@@ -42,7 +40,10 @@ func readISPMap(result *ISP, buffer []byte, mapSize, offset uint) (uint, error)
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown isp key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
@@ -54,7 +54,7 @@ func NewAnonymousIPReader(buffer []byte) (*AnonymousIPReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	if reader.metadata.DatabaseType != "GeoIP2-Anonymous-IP" {
+	if DatabaseKind(reader.metadata.DatabaseType) != KindAnonymousIP {
 		return nil, errors.New("wrong MaxMind DB Anonymous-IP type: " + reader.metadata.DatabaseType)
 	}
 	return &AnonymousIPReader{
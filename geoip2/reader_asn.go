@@ -54,9 +54,7 @@ func NewASNReader(buffer []byte) (*ASNReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	if reader.metadata.DatabaseType != "GeoLite2-ASN" &&
-		reader.metadata.DatabaseType != "DBIP-ASN-Lite" &&
-		reader.metadata.DatabaseType != "DBIP-ASN-Lite (compat=GeoLite2-ASN)" {
+	if DatabaseKind(reader.metadata.DatabaseType) != KindASN {
 		return nil, errors.New("wrong MaxMind DB ASN type: " + reader.metadata.DatabaseType)
 	}
 	return &ASNReader{
@@ -54,7 +54,7 @@ func NewISPReader(buffer []byte) (*ISPReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	if reader.metadata.DatabaseType != "GeoIP2-ISP" {
+	if DatabaseKind(reader.metadata.DatabaseType) != KindISP {
 		return nil, errors.New("wrong MaxMind DB ISP type: " + reader.metadata.DatabaseType)
 	}
 	return &ISPReader{
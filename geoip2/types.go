@@ -20,8 +20,95 @@ const (
 	dataTypeFloat32            = 15
 
 	dataSectionSeparatorSize = 16
+
+	// maxValueDepth nesting limit of the maps, slices and pointers decoded by readValue, as in libmaxminddb
+	maxValueDepth = 512
+)
+
+// Kinds of database, the reader a Metadata.DatabaseType is read with.
+const (
+	KindCity           = "City"
+	KindCountry        = "Country"
+	KindASN            = "ASN"
+	KindISP            = "ISP"
+	KindAnonymousIP    = "Anonymous-IP"
+	KindConnectionType = "Connection-Type"
+	KindDomain         = "Domain"
+)
+
+// databaseKinds kind of each supported Metadata.DatabaseType, the DB-IP types compatible with a MaxMind one
+// included. The readers accept the types of their kind only.
+//
+//nolint:gochecknoglobals
+var databaseKinds = map[string]string{
+	"GeoIP2-City":                           KindCity,
+	"GeoLite2-City":                         KindCity,
+	"GeoIP2-Enterprise":                     KindCity,
+	"DBIP-City-Lite":                        KindCity,
+	"DBIP-Location (compat=City)":           KindCity,
+	"DBIP-Location-ISP (compat=Enterprise)": KindCity,
+	"GeoIP2-Country":                        KindCountry,
+	"GeoLite2-Country":                      KindCountry,
+	"DBIP-Country":                          KindCountry,
+	"DBIP-Country-Lite":                     KindCountry,
+	"GeoLite2-ASN":                          KindASN,
+	"DBIP-ASN-Lite":                         KindASN,
+	"DBIP-ASN-Lite (compat=GeoLite2-ASN)":   KindASN,
+	"GeoIP2-ISP":                            KindISP,
+	"DBIP-ISP (compat=ISP)":                 KindISP,
+	"GeoIP2-Anonymous-IP":                   KindAnonymousIP,
+	"GeoIP2-Connection-Type":                KindConnectionType,
+	"GeoIP2-Domain":                         KindDomain,
+}
+
+// DatabaseKind returns the kind of a Metadata.DatabaseType, "" when no reader supports it.
+func DatabaseKind(databaseType string) string {
+	return databaseKinds[databaseType]
+}
+
+// RecordKeys selects the top level keys of a City or Country record decoded by LookupKeys,
+// the values of the other keys are skipped.
+type RecordKeys uint16
+
+const (
+	KeyCity RecordKeys = 1 << iota
+	KeyContinent
+	KeyCountry
+	KeyLocation
+	KeyPostal
+	KeyRegisteredCountry
+	KeyRepresentedCountry
+	KeySubdivisions
+	KeyTraits
+
+	KeysAll = KeyCity | KeyContinent | KeyCountry | KeyLocation | KeyPostal |
+		KeyRegisteredCountry | KeyRepresentedCountry | KeySubdivisions | KeyTraits
 )
 
+func (k RecordKeys) has(key string) bool {
+	switch key {
+	case "city":
+		return k&KeyCity != 0
+	case "continent":
+		return k&KeyContinent != 0
+	case "country":
+		return k&KeyCountry != 0
+	case "location":
+		return k&KeyLocation != 0
+	case "postal":
+		return k&KeyPostal != 0
+	case "registered_country":
+		return k&KeyRegisteredCountry != 0
+	case "represented_country":
+		return k&KeyRepresentedCountry != 0
+	case "subdivisions":
+		return k&KeySubdivisions != 0
+	case "traits":
+		return k&KeyTraits != 0
+	}
+	return true
+}
+
 type Continent struct {
 	GeoNameID uint32
 	Code      string
@@ -1,7 +1,5 @@
 package geoip2_iso88591
 
-import "errors"
-
 func readAnonymousIPMap(result *AnonymousIP, buffer []byte, mapSize, offset uint) (uint, error) {
 	var key []byte
 	var err error
@@ -42,7 +40,10 @@ func readAnonymousIPMap(result *AnonymousIP, buffer []byte, mapSize, offset uint
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown anonymous ip key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
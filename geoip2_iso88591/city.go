@@ -60,7 +60,10 @@ func readCityMap(city *City, buffer []byte, mapSize, offset uint) (uint, error)
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown city key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
@@ -60,7 +60,10 @@ func readContinentMap(continent *Continent, buffer []byte, mapSize, offset uint)
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown continent key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
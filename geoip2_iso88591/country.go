@@ -75,7 +75,10 @@ func readCountryMap(country *Country, buffer []byte, mapSize, offset uint) (uint
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown country key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
@@ -70,7 +70,10 @@ func readLocationMap(location *Location, buffer []byte, mapSize, offset uint) (u
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown location key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
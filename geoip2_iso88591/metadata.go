@@ -1,6 +1,7 @@
 package geoip2_iso88591
 
 import (
+	"bytes"
 	"errors"
 	"strconv"
 )
@@ -19,6 +20,15 @@ type Metadata struct {
 
 var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
 
+// ReadMetadata reads the metadata of a database of any type, without loading its search tree.
+func ReadMetadata(buffer []byte) (*Metadata, error) {
+	metadataStart := bytes.LastIndex(buffer, metadataStartMarker)
+	if metadataStart == -1 {
+		return nil, errors.New("invalid MaxMind DB: metadata not found")
+	}
+	return readMetadata(buffer[metadataStart+len(metadataStartMarker):])
+}
+
 func readMetadata(buffer []byte) (*Metadata, error) {
 	dataType, metadataSize, offset, err := readControl(buffer, 0)
 	if err != nil {
@@ -34,6 +44,7 @@ func readMetadata(buffer []byte) (*Metadata, error) {
 		if err != nil {
 			return nil, err
 		}
+		valueOffset := offset
 		size := uint(0)
 		dataType, size, offset, err = readControl(buffer, offset)
 		if err != nil {
@@ -100,7 +111,11 @@ func readMetadata(buffer []byte) (*Metadata, error) {
 			newOffset = offset + size
 			metadata.RecordSize = uint16(bytesToUInt64(buffer[offset:newOffset]))
 		default:
-			return nil, errors.New("unknown key: " + string(key) + ", type: " + strconv.Itoa(int(dataType)))
+			// added by a newer format version
+			newOffset, err = skipValue(buffer, valueOffset)
+			if err != nil {
+				return nil, err
+			}
 		}
 		offset = newOffset
 	}
@@ -55,7 +55,10 @@ func readPostalMap(postal *Postal, buffer []byte, mapSize, offset uint) (uint, e
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown postal key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
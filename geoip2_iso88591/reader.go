@@ -19,6 +19,11 @@ type reader struct {
 	nodeOffsetMult    uint
 }
 
+// Metadata returns a copy of the database metadata.
+func (r *reader) Metadata() Metadata {
+	return *r.metadata
+}
+
 func (r *reader) getOffset(ip net.IP) (uint, error) {
 	pointer, err := r.lookupPointer(ip)
 	if err != nil {
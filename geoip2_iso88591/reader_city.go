@@ -12,6 +12,10 @@ type CityReader struct {
 }
 
 func (r *CityReader) Lookup(ip net.IP) (*CityResult, error) {
+	return r.LookupKeys(ip, KeysAll)
+}
+
+func (r *CityReader) LookupKeys(ip net.IP, keys RecordKeys) (*CityResult, error) {
 	offset, err := r.getOffset(ip)
 	if err != nil {
 		return nil, err
@@ -30,6 +34,13 @@ func (r *CityReader) Lookup(ip net.IP) (*CityResult, error) {
 		if err != nil {
 			return nil, err
 		}
+		if !keys.has(bytesToKeyString(key)) {
+			offset, err = skipValue(r.decoderBuffer, offset)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
 		switch bytesToKeyString(key) {
 		case "city":
 			offset, err = readCity(&result.City, r.decoderBuffer, offset)
@@ -77,7 +88,10 @@ func (r *CityReader) Lookup(ip net.IP) (*CityResult, error) {
 				return nil, err
 			}
 		default:
-			return nil, errors.New("unknown City response key: " + string(key) + ", type: " + strconv.Itoa(int(dataType)))
+			offset, err = skipValue(r.decoderBuffer, offset)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	return result, nil
@@ -88,10 +102,7 @@ func NewCityReader(buffer []byte) (*CityReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	if reader.metadata.DatabaseType != "GeoIP2-City" &&
-		reader.metadata.DatabaseType != "GeoLite2-City" &&
-		reader.metadata.DatabaseType != "GeoIP2-Enterprise" &&
-		reader.metadata.DatabaseType != "DBIP-City-Lite" {
+	if DatabaseKind(reader.metadata.DatabaseType) != KindCity {
 		return nil, errors.New("wrong MaxMind DB City type: " + reader.metadata.DatabaseType)
 	}
 	return &CityReader{
@@ -54,7 +54,7 @@ func NewConnectionTypeReader(buffer []byte) (*ConnectionTypeReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	if reader.metadata.DatabaseType != "GeoIP2-Connection-Type" {
+	if DatabaseKind(reader.metadata.DatabaseType) != KindConnectionType {
 		return nil, errors.New("wrong MaxMind DB Connection-Type type: " + reader.metadata.DatabaseType)
 	}
 	return &ConnectionTypeReader{
@@ -12,6 +12,10 @@ type CountryReader struct {
 }
 
 func (r *CountryReader) Lookup(ip net.IP) (*CountryResult, error) {
+	return r.LookupKeys(ip, KeysAll)
+}
+
+func (r *CountryReader) LookupKeys(ip net.IP, keys RecordKeys) (*CountryResult, error) {
 	offset, err := r.getOffset(ip)
 	if err != nil {
 		return nil, err
@@ -30,6 +34,13 @@ func (r *CountryReader) Lookup(ip net.IP) (*CountryResult, error) {
 		if err != nil {
 			return nil, err
 		}
+		if !keys.has(bytesToKeyString(key)) {
+			offset, err = skipValue(r.decoderBuffer, offset)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
 		switch bytesToKeyString(key) {
 		case "continent":
 			offset, err = readContinent(&result.Continent, r.decoderBuffer, offset)
@@ -57,7 +68,10 @@ func (r *CountryReader) Lookup(ip net.IP) (*CountryResult, error) {
 				return nil, err
 			}
 		default:
-			return nil, errors.New("unknown Country response key: " + string(key) + ", type: " + strconv.Itoa(int(dataType)))
+			offset, err = skipValue(r.decoderBuffer, offset)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	return result, nil
@@ -68,10 +82,7 @@ func NewCountryReader(buffer []byte) (*CountryReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	if reader.metadata.DatabaseType != "GeoIP2-Country" &&
-		reader.metadata.DatabaseType != "GeoLite2-Country" &&
-		reader.metadata.DatabaseType != "DBIP-Country" &&
-		reader.metadata.DatabaseType != "DBIP-Country-Lite" {
+	if DatabaseKind(reader.metadata.DatabaseType) != KindCountry {
 		return nil, errors.New("wrong MaxMind DB Country type: " + reader.metadata.DatabaseType)
 	}
 	return &CountryReader{
@@ -54,7 +54,7 @@ func NewDomainReader(buffer []byte) (*DomainReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	if reader.metadata.DatabaseType != "GeoIP2-Domain" {
+	if DatabaseKind(reader.metadata.DatabaseType) != KindDomain {
 		return nil, errors.New("wrong MaxMind DB Domain type: " + reader.metadata.DatabaseType)
 	}
 	return &DomainReader{
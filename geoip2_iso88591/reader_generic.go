@@ -0,0 +1,43 @@
+package geoip2_iso88591
+
+import (
+	"io/ioutil"
+	"net"
+)
+
+// GenericReader reads the records of a DB of any type, e.g. IPinfo or ipapi, as a tree of values.
+type GenericReader struct {
+	*reader
+}
+
+// Lookup decodes the record of ip, a map[string]interface{} in most DBs, see readValue for the value types.
+func (r *GenericReader) Lookup(ip net.IP) (interface{}, error) {
+	offset, err := r.getOffset(ip)
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := readValue(r.decoderBuffer, offset)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// NewGenericReader accepts a DB of any type.
+func NewGenericReader(buffer []byte) (*GenericReader, error) {
+	reader, err := newReader(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &GenericReader{
+		reader: reader,
+	}, nil
+}
+
+func NewGenericReaderFromFile(filename string) (*GenericReader, error) {
+	buffer, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewGenericReader(buffer)
+}
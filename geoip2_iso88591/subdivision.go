@@ -107,7 +107,10 @@ func readSubdivisionMap(subdivision *Subdivision, buffer []byte, mapSize, offset
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown subdivision key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
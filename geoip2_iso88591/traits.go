@@ -110,7 +110,10 @@ func readTraitsMap(traits *Traits, buffer []byte, mapSize, offset uint) (uint, e
 				return 0, err
 			}
 		default:
-			return 0, errors.New("unknown traits key: " + string(key))
+			offset, err = skipValue(buffer, offset)
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 	return offset, nil
@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// PolicyAllow lets requests pass when their data can't be resolved.
+	PolicyAllow = "allow"
+	// PolicyBlock rejects requests when their data can't be resolved.
+	PolicyBlock = "block"
+)
+
+// HasCountryRules reports whether any country access rule is configured.
+func (options *Options) HasCountryRules() bool {
+	return len(options.AllowedCountries) > 0 || len(options.BlockedCountries) > 0 || options.UnknownCountryPolicy == PolicyBlock
+}
+
+// isCountryAllowed reports whether requests from countryCode pass the country rules.
+func (options *Options) isCountryAllowed(countryCode string) bool {
+	if countryCode == "" || countryCode == Unknown {
+		switch options.UnknownCountryPolicy {
+		case PolicyAllow:
+			return true
+		case PolicyBlock:
+			return false
+		default:
+			// without an explicit policy an allowlist only lets known countries in
+			return len(options.AllowedCountries) == 0
+		}
+	}
+	countryCode = strings.ToUpper(countryCode)
+	if contains(options.BlockedCountries, countryCode) {
+		return false
+	}
+	return len(options.AllowedCountries) == 0 || contains(options.AllowedCountries, countryCode)
+}
+
+// HasAsnRules reports whether any ASN access rule is configured.
+func (options *Options) HasAsnRules() bool {
+	return options.hasAsnAllowRules() || len(options.BlockedAsns) > 0 || len(options.BlockedAsnOrganizations) > 0 ||
+		options.UnknownAsnPolicy == PolicyBlock
+}
+
+func (options *Options) hasAsnAllowRules() bool {
+	return len(options.AllowedAsns) > 0 || len(options.AllowedAsnOrganizations) > 0
+}
+
+// isAsnAllowed reports whether requests from the autonomous system pass the ASN rules.
+func (options *Options) isAsnAllowed(number, organization string) bool {
+	if number == "" || number == Unknown || number == "0" {
+		switch options.UnknownAsnPolicy {
+		case PolicyAllow:
+			return true
+		case PolicyBlock:
+			return false
+		default:
+			return !options.hasAsnAllowRules()
+		}
+	}
+	organization = strings.ToLower(organization)
+	if contains(options.BlockedAsns, number) || containsSubstring(organization, options.BlockedAsnOrganizations) {
+		return false
+	}
+	return !options.hasAsnAllowRules() ||
+		contains(options.AllowedAsns, number) || containsSubstring(organization, options.AllowedAsnOrganizations)
+}
+
+// denyCountry writes a 403 response when countryCode doesn't pass the country rules.
+func denyCountry(reqWr http.ResponseWriter, options *Options, ipStr, countryCode string) bool {
+	if options.isCountryAllowed(countryCode) {
+		return false
+	}
+	if options.Debug {
+		log.Printf("[geoip2] Request blocked by country rules: ip=%s, country=%s", ipStr, countryCode)
+	}
+	forbidden(reqWr)
+	return true
+}
+
+// denyAsn writes a 403 response when the autonomous system doesn't pass the ASN rules.
+func denyAsn(reqWr http.ResponseWriter, options *Options, ipStr, number, organization string) bool {
+	if options.isAsnAllowed(number, organization) {
+		return false
+	}
+	if options.Debug {
+		log.Printf("[geoip2] Request blocked by ASN rules: ip=%s, asn=%s, organization=%s", ipStr, number, organization)
+	}
+	forbidden(reqWr)
+	return true
+}
+
+// denyNetworkType writes a 403 response when the network type of the client IP is blocked.
+func denyNetworkType(reqWr http.ResponseWriter, options *Options, ipStr, networkType string) bool {
+	if !contains(options.BlockedNetworkTypes, networkType) {
+		return false
+	}
+	if options.Debug {
+		log.Printf("[geoip2] Request blocked by network type rules: ip=%s, type=%s", ipStr, networkType)
+	}
+	forbidden(reqWr)
+	return true
+}
+
+// denyNotLookedUp writes a 403 response when the access rules block an unknown country or ASN, a non-public IP
+// is not looked up so it is unknown, unless its network type is allowed.
+func denyNotLookedUp(reqWr http.ResponseWriter, options *Options, ipStr, networkType string) bool {
+	if contains(options.AllowedNetworkTypes, networkType) ||
+		(options.isCountryAllowed(Unknown) && options.isAsnAllowed(Unknown, "")) {
+		return false
+	}
+	if options.Debug {
+		log.Printf("[geoip2] Request blocked by the unknown country or ASN rules: ip=%s, type=%s", ipStr, networkType)
+	}
+	forbidden(reqWr)
+	return true
+}
+
+// denyAnonymous writes a 403 response when one of the blocked anonymity categories matches.
+func denyAnonymous(reqWr http.ResponseWriter, options *Options, ipStr string, res *GeoIPAnonymousResult) bool {
+	var category string
+	switch {
+	case options.BlockAnonymous && res.isAnonymous:
+		category = "anonymous"
+	case options.BlockAnonymousVPN && res.isAnonymousVPN:
+		category = "vpn"
+	case options.BlockHostingProviders && res.isHostingProvider:
+		category = "hosting"
+	case options.BlockPublicProxies && res.isPublicProxy:
+		category = "public proxy"
+	case options.BlockTorExitNodes && res.isTorExitNode:
+		category = "tor exit node"
+	case options.BlockResidentialProxies && res.isResidentialProxy:
+		category = "residential proxy"
+	default:
+		return false
+	}
+	if options.Debug {
+		log.Printf("[geoip2] Request blocked by anonymous ip rules: ip=%s, category=%s", ipStr, category)
+	}
+	forbidden(reqWr)
+	return true
+}
+
+func forbidden(reqWr http.ResponseWriter) {
+	http.Error(reqWr, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSubstring(value string, substrings []string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range substrings {
+		if strings.Contains(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func toLower(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, strings.ToLower(v))
+		}
+	}
+	return result
+}
+
+// toAsnNumbers normalizes "AS13335" and "13335" to "13335".
+func toAsnNumbers(values []string) []string {
+	result := toUpper(values)
+	for i, v := range result {
+		result[i] = strings.TrimPrefix(v, "AS")
+	}
+	return result
+}
+
+func toUpper(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, strings.ToUpper(v))
+		}
+	}
+	return result
+}
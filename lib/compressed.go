@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// tarMagicOffset offset of the ustar magic in the header of a tar archive.
+const tarMagicOffset = 257
+
+// isGzip reports whether data starts with the gzip magic bytes.
+func isGzip(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// isTar reports whether data starts with a ustar header, as GNU tar and MaxMind archives do.
+func isTar(data []byte) bool {
+	return len(data) > tarMagicOffset+5 && string(data[tarMagicOffset:tarMagicOffset+5]) == "ustar"
+}
+
+// isCompressedDB reports whether data is a gzip or tar file rather than a raw MMDB.
+func isCompressedDB(data []byte) bool {
+	return isGzip(data) || isTar(data)
+}
+
+// openCompressedDB creates the reader of a DB file with newReader, .mmdb.gz, tar and tar.gz files are detected by their
+// magic bytes and decompressed in memory. The .mmdb members of an archive are tried in order, the first one newReader
+// accepts is used, so an archive holding the City, Country and ASN DBs fits every DB path.
+func openCompressedDB(data []byte, newReader func(buffer []byte) (interface{}, error)) (interface{}, error) {
+	if isGzip(data) {
+		var err error
+		if data, err = gunzip(data); err != nil {
+			return nil, err
+		}
+	}
+	if isTar(data) {
+		return tarMMDB(data, newReader)
+	}
+	return newReader(data)
+}
+
+// extractMMDB returns the .mmdb member of a tar.gz archive.
+func extractMMDB(archive []byte) ([]byte, error) {
+	data, err := gunzip(archive)
+	if err != nil {
+		return nil, err
+	}
+	db, err := tarMMDB(data, func(buffer []byte) (interface{}, error) {
+		return buffer, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.([]byte), nil
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer gzipReader.Close()
+	data, err = io.ReadAll(io.LimitReader(gzipReader, maxDownloadSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return data, nil
+}
+
+// tarMMDB returns the reader of the first .mmdb member of a tar archive accepted by newReader.
+func tarMMDB(data []byte, newReader func(buffer []byte) (interface{}, error)) (interface{}, error) {
+	tarReader := tar.NewReader(bytes.NewReader(data))
+	var readerErr error
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			if readerErr != nil {
+				return nil, readerErr
+			}
+			return nil, errors.New("no .mmdb file in the archive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(path.Base(header.Name), ".mmdb") {
+			continue
+		}
+		db, err := io.ReadAll(io.LimitReader(tarReader, maxDownloadSize))
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		reader, err := newReader(db)
+		if err == nil {
+			return reader, nil
+		}
+		readerErr = fmt.Errorf("%s: %w", header.Name, err)
+	}
+}
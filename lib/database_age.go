@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// StalePolicyWarn only logs the DBs older than maxDatabaseAge.
+	StalePolicyWarn = "warn"
+	// StalePolicyDegrade only processes the IP headers when a DB is older than maxDatabaseAge.
+	StalePolicyDegrade = "degrade"
+	// StalePolicyFail refuses to start when a DB is older than maxDatabaseAge.
+	StalePolicyFail = "fail"
+)
+
+// ErrStaleDatabase a DB is older than maxDatabaseAge.
+var ErrStaleDatabase = errors.New("stale GeoIP DB")
+
+// databaseBuilds build time of each loaded DB, shared by the copies of the options so the reloaded DBs update it.
+type databaseBuilds struct {
+	mutex  sync.Mutex
+	builds map[string]time.Time
+	oldest string
+}
+
+func newDatabaseBuilds() *databaseBuilds {
+	return &databaseBuilds{builds: map[string]time.Time{}}
+}
+
+// loadedDB records the build time of a loaded DB and logs a warning when it is older than maxDatabaseAge.
+func (options *Options) loadedDB(buildEpoch uint64, dbPath, name string) {
+	build := time.Unix(int64(buildEpoch), 0).UTC()
+	if age := time.Since(build); options.maxDatabaseAge > 0 && age > options.maxDatabaseAge {
+		log.Printf("[geoip2] DB is older than maxDatabaseAge: db=%s, name=%s, build=%s, age=%s, maxDatabaseAge=%s",
+			dbPath, name, build.Format(time.RFC3339), age.Round(time.Hour), options.maxDatabaseAge)
+	}
+	if options.databaseBuilds == nil {
+		return
+	}
+	options.databaseBuilds.mutex.Lock()
+	defer options.databaseBuilds.mutex.Unlock()
+	options.databaseBuilds.builds[dbPath] = build
+	var oldest time.Time
+	for _, dbBuild := range options.databaseBuilds.builds {
+		if oldest.IsZero() || dbBuild.Before(oldest) {
+			oldest = dbBuild
+		}
+	}
+	options.databaseBuilds.oldest = oldest.Format("2006-01-02")
+}
+
+// databaseBuildDate returns the build date of the oldest loaded DB.
+func (options *Options) databaseBuildDate() string {
+	if options.databaseBuilds == nil {
+		return Unknown
+	}
+	options.databaseBuilds.mutex.Lock()
+	defer options.databaseBuilds.mutex.Unlock()
+	return valueOrUnknown(options.databaseBuilds.oldest)
+}
+
+// CheckDatabaseAge returns ErrStaleDatabase when a loaded DB is older than maxDatabaseAge.
+func (options *Options) CheckDatabaseAge() error {
+	if options.maxDatabaseAge <= 0 || options.databaseBuilds == nil {
+		return nil
+	}
+	options.databaseBuilds.mutex.Lock()
+	defer options.databaseBuilds.mutex.Unlock()
+	for dbPath, build := range options.databaseBuilds.builds {
+		if time.Since(build) > options.maxDatabaseAge {
+			return fmt.Errorf("%w: db=%s, build=%s", ErrStaleDatabase, dbPath, build.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
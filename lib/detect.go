@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+)
+
+// databaseOptions DB path option each kind of database is routed to.
+//
+//nolint:gochecknoglobals
+var databaseOptions = map[string]string{
+	geoip2.KindCity:           "cityDbPath",
+	geoip2.KindCountry:        "countryDbPath",
+	geoip2.KindASN:            "asnDbPath",
+	geoip2.KindISP:            "ispDbPath",
+	geoip2.KindAnonymousIP:    "anonymousIpDbPath",
+	geoip2.KindConnectionType: "connectionTypeDbPath",
+	geoip2.KindDomain:         "domainDbPath",
+}
+
+// databaseKind returns the option of a database type, the types are the ones the readers accept, see
+// geoip2.DatabaseKind.
+func databaseKind(databaseType string) (string, bool) {
+	kind, ok := databaseOptions[geoip2.DatabaseKind(databaseType)]
+	return kind, ok
+}
+
+// DetectDatabases returns a copy of config with each file of the databases option routed to the DB path option of
+// its type. The City, Country and ASN DBs after the first one are added to the fallback chain of their type.
+func DetectDatabases(config *Config, name string) (*Config, error) {
+	if len(config.Databases) == 0 {
+		return config, nil
+	}
+	detected := *config
+	detected.CityDBPaths = append([]string(nil), config.CityDBPaths...)
+	detected.CountryDBPaths = append([]string(nil), config.CountryDBPaths...)
+	detected.AsnDBPaths = append([]string(nil), config.AsnDBPaths...)
+	for _, dbPath := range config.Databases {
+		if dbPath = strings.TrimSpace(dbPath); dbPath == "" {
+			continue
+		}
+		metadata, err := readDBMetadata(dbPath)
+		if err != nil {
+			return nil, fmt.Errorf("database type not detected: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		databaseType := metadata.DatabaseType
+		kind, ok := databaseKind(databaseType)
+		if !ok {
+			return nil, fmt.Errorf("unsupported database type: db=%s, name=%s, type=%s", dbPath, name, databaseType)
+		}
+		if err := detected.addDatabase(kind, dbPath); err != nil {
+			return nil, fmt.Errorf("%w: db=%s, name=%s, type=%s", err, dbPath, name, databaseType)
+		}
+		log.Printf("[geoip2] Database detected: db=%s, name=%s, type=%s, option=%s", dbPath, name, databaseType, kind)
+	}
+	return &detected, nil
+}
+
+// metadataMaxSize upper bound of the metadata section, marker included, at the end of a MaxMind DB.
+const metadataMaxSize = 128 * 1024
+
+// readDBMetadata reads the metadata of a DB from the end of the file, without loading the search tree and the data
+// section. A compressed DB is decompressed in memory, its metadata can't be reached otherwise.
+func readDBMetadata(dbPath string) (*geoip2.Metadata, error) {
+	file, err := os.Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	head := make([]byte, tarMagicOffset+6)
+	read, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if isCompressedDB(head[:read]) {
+		metadata, err := openDB(dbPath, false, func(buffer []byte) (interface{}, error) {
+			return geoip2.ReadMetadata(buffer)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return metadata.(*geoip2.Metadata), nil
+	}
+
+	offset := int64(0)
+	if info.Size() > metadataMaxSize {
+		offset = info.Size() - metadataMaxSize
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return geoip2.ReadMetadata(tail)
+}
+
+// addDatabase sets the DB path option kind, or adds the DB to the fallback chain of the City, Country and ASN DBs.
+func (config *Config) addDatabase(kind, dbPath string) error {
+	var single *string
+	switch kind {
+	case "cityDbPath":
+		if config.CityDBPath == "" {
+			config.CityDBPath = dbPath
+		} else {
+			config.CityDBPaths = append(config.CityDBPaths, dbPath)
+		}
+		return nil
+	case "countryDbPath":
+		if config.CountryDBPath == "" {
+			config.CountryDBPath = dbPath
+		} else {
+			config.CountryDBPaths = append(config.CountryDBPaths, dbPath)
+		}
+		return nil
+	case "asnDbPath":
+		if config.AsnDBPath == "" {
+			config.AsnDBPath = dbPath
+		} else {
+			config.AsnDBPaths = append(config.AsnDBPaths, dbPath)
+		}
+		return nil
+	case "ispDbPath":
+		single = &config.ISPDBPath
+	case "anonymousIpDbPath":
+		single = &config.AnonymousIPDBPath
+	case "connectionTypeDbPath":
+		single = &config.ConnectionTypeDBPath
+	case "domainDbPath":
+		single = &config.DomainDBPath
+	}
+	if *single != "" {
+		return fmt.Errorf("%s is already set to %s", kind, *single)
+	}
+	*single = dbPath
+	return nil
+}
@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// isUnknown reports whether a DB left the value empty.
+func isUnknown(value string) bool {
+	return value == "" || value == Unknown
+}
+
+// isUnknownOrZero reports whether a value is unknown or the zero some DBs store in place of a missing number, e.g.
+// the coordinates, accuracy radius or ASN of a record without them, so the next DBs still fill it.
+func isUnknownOrZero(value string) bool {
+	return isUnknown(value) || value == "0"
+}
+
+// locationOf returns the latitude of a result, Unknown when its coordinates are the 0, 0 placeholder.
+func locationOf(res *GeoIPCityResult) string {
+	if isUnknownOrZero(res.latitude) && isUnknownOrZero(res.longitude) {
+		return Unknown
+	}
+	return res.latitude
+}
+
+// addSource appends the source of a DB that filled some fields.
+func addSource(sources, source string) string {
+	switch {
+	case source == "" || strings.Contains(","+sources+",", ","+source+","):
+		return sources
+	case sources == "":
+		return source
+	default:
+		return sources + "," + source
+	}
+}
+
+// CreateCountryCityLookup uses a Country lookup in a City fallback chain, the City fields are unknown.
+func CreateCountryCityLookup(lookup LookupGeoIPCountry) LookupGeoIPCity {
+	return func(ip net.IP) (*GeoIPCityResult, error) {
+		res, err := lookup(ip)
+		if err != nil {
+			return nil, err
+		}
+		return &GeoIPCityResult{
+			GeoIPCountryResult: *res,
+			region:             Unknown,
+			regionCode:         Unknown,
+			subdivisions:       Unknown,
+			subdivisionCodes:   Unknown,
+			city:               Unknown,
+			postalCode:         Unknown,
+			latitude:           Unknown,
+			longitude:          Unknown,
+			accuracyRadius:     Unknown,
+			geohash:            Unknown,
+			timeZone:           Unknown,
+			metroCode:          Unknown,
+		}, nil
+	}
+}
+
+// CreateFallbackCityLookup looks up the DBs in order, each field comes from the first DB that has it.
+// The DBs after the first one only fill the region, city and location of the same country, they are skipped once
+// every field wanted by options is known.
+func CreateFallbackCityLookup(lookups []LookupGeoIPCity, options *Options) LookupGeoIPCity {
+	if len(lookups) == 1 {
+		return lookups[0]
+	}
+	return func(ip net.IP) (*GeoIPCityResult, error) {
+		var result *GeoIPCityResult
+		var lastErr error
+		for _, lookup := range lookups {
+			res, err := lookup(ip)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if result == nil {
+				result = res
+			} else {
+				fillCityResult(result, res)
+			}
+			if isCityComplete(result, options) {
+				break
+			}
+		}
+		if result == nil {
+			return nil, lastErr
+		}
+		return result, nil
+	}
+}
+
+// CreateFallbackCountryLookup looks up the DBs in order, each field comes from the first DB that has it.
+// The next DBs are skipped once every field wanted by options is known.
+func CreateFallbackCountryLookup(lookups []LookupGeoIPCountry, options *Options) LookupGeoIPCountry {
+	if len(lookups) == 1 {
+		return lookups[0]
+	}
+	return func(ip net.IP) (*GeoIPCountryResult, error) {
+		var result *GeoIPCountryResult
+		var lastErr error
+		for _, lookup := range lookups {
+			res, err := lookup(ip)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if result == nil {
+				result = res
+			} else {
+				fillCountryResult(result, res)
+			}
+			if isCountryComplete(result, options) {
+				break
+			}
+		}
+		if result == nil {
+			return nil, lastErr
+		}
+		return result, nil
+	}
+}
+
+// CreateFallbackAsnLookup looks up the DBs in order until one knows the ASN, the ISP fields come from the ISP DB even
+// when the ASN comes from a later DB.
+func CreateFallbackAsnLookup(lookups []LookupGeoIPAsn) LookupGeoIPAsn {
+	if len(lookups) == 1 {
+		return lookups[0]
+	}
+	return func(ip net.IP) (*GeoIPAsnResult, error) {
+		var result *GeoIPAsnResult
+		var lastErr error
+		for _, lookup := range lookups {
+			res, err := lookup(ip)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if result == nil {
+				result = res
+			}
+			if !isUnknownOrZero(res.number) {
+				if res.isp == nil && result.isp != nil {
+					merged := *res
+					merged.isp = result.isp
+					return &merged, nil
+				}
+				return res, nil
+			}
+		}
+		if result == nil {
+			return nil, lastErr
+		}
+		return result, nil
+	}
+}
+
+// fillCountryResult fills the unknown fields of dst from src.
+func fillCountryResult(dst, src *GeoIPCountryResult) bool {
+	filled := false
+	if isUnknownOrZero(dst.countryCode) && !isUnknownOrZero(src.countryCode) {
+		dst.country, dst.countryCode, dst.countryNames = src.country, src.countryCode, src.countryNames
+		dst.isInEuropeanUnion = src.isInEuropeanUnion
+		filled = true
+	}
+	if isUnknownOrZero(dst.continentCode) && !isUnknownOrZero(src.continentCode) {
+		dst.continent, dst.continentCode = src.continent, src.continentCode
+		filled = true
+	}
+	if isUnknownOrZero(dst.registeredCountryCode) && !isUnknownOrZero(src.registeredCountryCode) {
+		dst.registeredCountry, dst.registeredCountryCode = src.registeredCountry, src.registeredCountryCode
+		filled = true
+	}
+	if isUnknownOrZero(dst.representedCountryCode) && !isUnknownOrZero(src.representedCountryCode) {
+		dst.representedCountry, dst.representedCountryCode = src.representedCountry, src.representedCountryCode
+		filled = true
+	}
+	if filled {
+		if !isUnknownOrZero(dst.countryCode) && !isUnknownOrZero(dst.registeredCountryCode) {
+			dst.registeredCountryMismatch = strconv.FormatBool(isCountryMismatch(dst.countryCode, dst.registeredCountryCode))
+		}
+		dst.source = addSource(dst.source, src.source)
+	}
+	return filled
+}
+
+// fillCityResult fills the unknown fields of dst from src, the region, city and location only come from a DB
+// locating the IP in the same country.
+func fillCityResult(dst, src *GeoIPCityResult) {
+	filled := fillCountryResult(&dst.GeoIPCountryResult, &src.GeoIPCountryResult)
+	if dst.countryCode != src.countryCode {
+		return
+	}
+	if isUnknownOrZero(dst.regionCode) && isUnknownOrZero(dst.region) && (!isUnknownOrZero(src.regionCode) || !isUnknownOrZero(src.region)) {
+		dst.region, dst.regionCode, dst.regionNames = src.region, src.regionCode, src.regionNames
+		dst.subdivisions, dst.subdivisionCodes = src.subdivisions, src.subdivisionCodes
+		filled = true
+	}
+	if isUnknownOrZero(dst.city) && !isUnknownOrZero(src.city) {
+		dst.city, dst.cityNames = src.city, src.cityNames
+		filled = true
+	}
+	if isUnknownOrZero(dst.postalCode) && !isUnknownOrZero(src.postalCode) {
+		dst.postalCode = src.postalCode
+		filled = true
+	}
+	if isUnknown(locationOf(dst)) && !isUnknown(locationOf(src)) {
+		dst.latitude, dst.longitude, dst.accuracyRadius = src.latitude, src.longitude, src.accuracyRadius
+		dst.geohash = src.geohash
+		filled = true
+	}
+	if isUnknownOrZero(dst.accuracyRadius) && !isUnknownOrZero(src.accuracyRadius) && dst.latitude == src.latitude &&
+		dst.longitude == src.longitude {
+		dst.accuracyRadius = src.accuracyRadius
+		filled = true
+	}
+	if isUnknownOrZero(dst.metroCode) && !isUnknownOrZero(src.metroCode) {
+		dst.metroCode = src.metroCode
+		filled = true
+	}
+	if isUnknownOrZero(dst.timeZone) && !isUnknownOrZero(src.timeZone) {
+		dst.timeZone = src.timeZone
+		filled = true
+	}
+	if dst.enterprise == nil && src.enterprise != nil {
+		dst.enterprise = src.enterprise
+		filled = true
+	}
+	if filled {
+		dst.source = addSource(dst.source, src.source)
+	}
+}
+
+// isCountryComplete reports whether the next DBs can't add the country or continent, when they are wanted.
+func isCountryComplete(res *GeoIPCountryResult, options *Options) bool {
+	return !isMissing(options, res.countryCode, FieldCountry, FieldCountryCode, FieldIsInEuropeanUnion) &&
+		!isMissing(options, res.continentCode, FieldContinent, FieldContinentCode)
+}
+
+// isCityComplete reports whether the next DBs can't add any wanted field, e.g. a postal code.
+func isCityComplete(res *GeoIPCityResult, options *Options) bool {
+	// a DB knowing the region name without its code fills it as much as the next DBs would
+	region := res.regionCode
+	if isUnknownOrZero(region) {
+		region = res.region
+	}
+	return isCountryComplete(&res.GeoIPCountryResult, options) &&
+		!isMissing(options, region, FieldRegion, FieldRegionCode, FieldSubdivisions, FieldSubdivisionCodes) &&
+		!isMissing(options, res.city, FieldCity) &&
+		!isMissing(options, res.postalCode, FieldPostalCode) &&
+		// the accuracy radius and metro code come with the location, only US records have a metro code
+		!isMissing(options, locationOf(res), FieldLatitude, FieldLongitude, FieldAccuracyRadius, FieldGeohash, FieldMetroCode) &&
+		!isMissing(options, res.timeZone, FieldTimeZone)
+}
+
+// isMissing reports whether the value of a wanted field is unknown or zero.
+func isMissing(options *Options, value string, fields ...Field) bool {
+	return isUnknownOrZero(value) && options.Wants(fields...)
+}
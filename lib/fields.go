@@ -0,0 +1,317 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+)
+
+// Field is a piece of GeoIP data forwarded as a request header.
+type Field int
+
+// Fields selectable by the fields option.
+const (
+	FieldIPAddress Field = iota
+	FieldContinent
+	FieldContinentCode
+	FieldCountry
+	FieldCountryCode
+	FieldIsInEuropeanUnion
+	FieldRegisteredCountry
+	FieldRegisteredCountryCode
+	FieldRegisteredCountryMismatch
+	FieldRepresentedCountry
+	FieldRepresentedCountryCode
+	FieldRegion
+	FieldRegionCode
+	FieldSubdivisions
+	FieldSubdivisionCodes
+	FieldCity
+	FieldPostalCode
+	FieldLatitude
+	FieldLongitude
+	FieldAccuracyRadius
+	FieldGeohash
+	FieldTimeZone
+	FieldMetroCode
+	FieldASN
+	FieldASNOrganization
+	FieldISP
+	FieldOrganization
+	FieldMobileCountryCode
+	FieldMobileNetworkCode
+	FieldConnectionType
+	FieldDomain
+	FieldIsAnonymous
+	FieldIsAnonymousVPN
+	FieldIsHostingProvider
+	FieldIsPublicProxy
+	FieldIsTorExitNode
+	FieldIsResidentialProxy
+	FieldCountryConfidence
+	FieldRegionConfidence
+	FieldCityConfidence
+	FieldPostalConfidence
+	FieldUserType
+	FieldStaticIPScore
+	FieldIsLegitimateProxy
+	FieldNetworkType
+	FieldDatabaseBuildDate
+	FieldSource
+	FieldASNSource
+
+	fieldCount
+)
+
+// fieldDefinitions name of each field in the fields option and its header.
+//
+//nolint:gochecknoglobals
+var fieldDefinitions = [fieldCount]struct {
+	name   string
+	header string
+}{
+	FieldIPAddress:                 {"ip_address", IPAddressHeader},
+	FieldContinent:                 {"continent", ContinentHeader},
+	FieldContinentCode:             {"continent_code", ContinentCodeHeader},
+	FieldCountry:                   {"country", CountryHeader},
+	FieldCountryCode:               {"country_code", CountryCodeHeader},
+	FieldIsInEuropeanUnion:         {"is_in_european_union", IsInEuropeanUnionHeader},
+	FieldRegisteredCountry:         {"registered_country", RegisteredCountryHeader},
+	FieldRegisteredCountryCode:     {"registered_country_code", RegisteredCountryCodeHeader},
+	FieldRegisteredCountryMismatch: {"registered_country_mismatch", RegisteredCountryMismatchHeader},
+	FieldRepresentedCountry:        {"represented_country", RepresentedCountryHeader},
+	FieldRepresentedCountryCode:    {"represented_country_code", RepresentedCountryCodeHeader},
+	FieldRegion:                    {"region", RegionHeader},
+	FieldRegionCode:                {"region_code", RegionCodeHeader},
+	FieldSubdivisions:              {"subdivisions", SubdivisionsHeader},
+	FieldSubdivisionCodes:          {"subdivision_codes", SubdivisionCodesHeader},
+	FieldCity:                      {"city", CityHeader},
+	FieldPostalCode:                {"postal_code", PostalCodeHeader},
+	FieldLatitude:                  {"latitude", LatitudeHeader},
+	FieldLongitude:                 {"longitude", LongitudeHeader},
+	FieldAccuracyRadius:            {"accuracy_radius", AccuracyRadiusHeader},
+	FieldGeohash:                   {"geohash", GeohashHeader},
+	FieldTimeZone:                  {"time_zone", TimeZoneHeader},
+	FieldMetroCode:                 {"metro_code", MetroCodeHeader},
+	FieldASN:                       {"asn", ASNSystemNumberHeader},
+	FieldASNOrganization:           {"asn_organization", ASNOrganizationHeader},
+	FieldISP:                       {"isp", ISPHeader},
+	FieldOrganization:              {"organization", OrganizationHeader},
+	FieldMobileCountryCode:         {"mobile_country_code", MobileCountryCodeHeader},
+	FieldMobileNetworkCode:         {"mobile_network_code", MobileNetworkCodeHeader},
+	FieldConnectionType:            {"connection_type", ConnectionTypeHeader},
+	FieldDomain:                    {"domain", DomainHeader},
+	FieldIsAnonymous:               {"is_anonymous", IsAnonymousHeader},
+	FieldIsAnonymousVPN:            {"is_anonymous_vpn", IsAnonymousVPNHeader},
+	FieldIsHostingProvider:         {"is_hosting_provider", IsHostingProviderHeader},
+	FieldIsPublicProxy:             {"is_public_proxy", IsPublicProxyHeader},
+	FieldIsTorExitNode:             {"is_tor_exit_node", IsTorExitNodeHeader},
+	FieldIsResidentialProxy:        {"is_residential_proxy", IsResidentialProxyHeader},
+	FieldCountryConfidence:         {"country_confidence", CountryConfidenceHeader},
+	FieldRegionConfidence:          {"region_confidence", RegionConfidenceHeader},
+	FieldCityConfidence:            {"city_confidence", CityConfidenceHeader},
+	FieldPostalConfidence:          {"postal_confidence", PostalConfidenceHeader},
+	FieldUserType:                  {"user_type", UserTypeHeader},
+	FieldStaticIPScore:             {"static_ip_score", StaticIPScoreHeader},
+	FieldIsLegitimateProxy:         {"is_legitimate_proxy", IsLegitimateProxyHeader},
+	FieldNetworkType:               {"network_type", NetworkTypeHeader},
+	FieldDatabaseBuildDate:         {"db_build_date", DatabaseBuildDateHeader},
+	FieldSource:                    {"source", SourceHeader},
+	FieldASNSource:                 {"asn_source", ASNSourceHeader},
+}
+
+// defaultFields fields sent when no fields are configured, the headers sent before the fields option existed.
+// The other fields are opt-in, so an upgrade doesn't add headers to the requests.
+//
+//nolint:gochecknoglobals
+var defaultFields = []Field{
+	FieldIPAddress, FieldCountry, FieldCountryCode, FieldRegion, FieldRegionCode, FieldCity, FieldPostalCode,
+	FieldLatitude, FieldLongitude, FieldAccuracyRadius, FieldGeohash, FieldASN, FieldASNOrganization,
+}
+
+// lightModeFields fields sent when lightMode is set and no fields are configured.
+//
+//nolint:gochecknoglobals
+var lightModeFields = []Field{
+	FieldIPAddress, FieldCountryCode, FieldRegionCode, FieldCity,
+	FieldLatitude, FieldLongitude, FieldAccuracyRadius, FieldASN, FieldASNOrganization,
+}
+
+// enterpriseFields fields only found in the GeoIP2 Enterprise database.
+//
+//nolint:gochecknoglobals
+var enterpriseFields = []Field{
+	FieldCountryConfidence, FieldRegionConfidence, FieldCityConfidence, FieldPostalConfidence,
+	FieldUserType, FieldStaticIPScore, FieldIsLegitimateProxy, FieldISP, FieldOrganization,
+	FieldMobileCountryCode, FieldMobileNetworkCode, FieldConnectionType, FieldDomain,
+	FieldASN, FieldASNOrganization,
+}
+
+// lightModeOptions returns a copy of options sending only the light mode fields.
+func lightModeOptions(options Options) Options {
+	options.fields, _ = parseFields(nil, true)
+	return options
+}
+
+// fieldSet tells which fields are wanted, indexed by Field, a nil set wants every field.
+type fieldSet []bool
+
+func (s fieldSet) has(fields ...Field) bool {
+	if s == nil {
+		return true
+	}
+	for _, field := range fields {
+		if s[field] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFields parses the fields option, the default fields are wanted when names is empty. The "default" name adds
+// the default fields and "all" every field but db_build_date, e.g. [default, time_zone].
+func parseFields(names []string, lightMode bool) (fieldSet, error) {
+	set := make(fieldSet, fieldCount)
+	if len(names) == 0 {
+		if lightMode {
+			return set.add(lightModeFields), nil
+		}
+		return set.add(defaultFields), nil
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+			continue
+		case "default":
+			set.add(defaultFields)
+			continue
+		case "all":
+			for i := range set {
+				// opt-in, with databaseBuildDateHeader
+				set[i] = set[i] || Field(i) != FieldDatabaseBuildDate
+			}
+			continue
+		}
+		field, ok := fieldByName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field: %q", name)
+		}
+		set[field] = true
+	}
+	return set, nil
+}
+
+// add adds the fields to the set.
+func (s fieldSet) add(fields []Field) fieldSet {
+	for _, field := range fields {
+		s[field] = true
+	}
+	return s
+}
+
+// ruleFields fields the access rules need, even when they are not sent.
+func ruleFields(options *Options) fieldSet {
+	set := make(fieldSet, fieldCount)
+	set[FieldCountryCode] = options.HasCountryRules()
+	set[FieldASN] = options.HasAsnRules()
+	set[FieldASNOrganization] = options.HasAsnRules()
+	set[FieldIsAnonymous] = options.BlockAnonymous
+	set[FieldIsAnonymousVPN] = options.BlockAnonymousVPN
+	set[FieldIsHostingProvider] = options.BlockHostingProviders
+	set[FieldIsPublicProxy] = options.BlockPublicProxies
+	set[FieldIsTorExitNode] = options.BlockTorExitNodes
+	set[FieldIsResidentialProxy] = options.BlockResidentialProxies
+	return set
+}
+
+func fieldByName(name string) (Field, bool) {
+	for i := range fieldDefinitions {
+		if fieldDefinitions[i].name == name {
+			return Field(i), true
+		}
+	}
+	return 0, false
+}
+
+// defaultHeaderPrefix prefix of the default header names, replaced by the headerPrefix option.
+const defaultHeaderPrefix = "GeoIP-"
+
+// parseHeaders builds the header name of each field from the headerPrefix and headers options.
+func parseHeaders(prefix string, overrides map[string]string) ([]string, error) {
+	headers := make([]string, fieldCount)
+	for i := range fieldDefinitions {
+		headers[i] = fieldDefinitions[i].header
+		if prefix != "" {
+			headers[i] = prefix + strings.TrimPrefix(headers[i], defaultHeaderPrefix)
+		}
+	}
+	for name, header := range overrides {
+		field, ok := fieldByName(strings.ToLower(strings.TrimSpace(name)))
+		if !ok {
+			return nil, fmt.Errorf("unknown field in headers: %q", name)
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			return nil, fmt.Errorf("empty header name for field: %q", name)
+		}
+		headers[field] = header
+	}
+	return headers, nil
+}
+
+// setHeader sets the header of field when it is wanted.
+func (options *Options) setHeader(req *http.Request, field Field, value string) {
+	if options.fields.has(field) {
+		req.Header.Set(options.headerName(field), value)
+	}
+}
+
+// headerName returns the header name of field.
+func (options *Options) headerName(field Field) string {
+	if options.headers != nil {
+		return options.headers[field]
+	}
+	return fieldDefinitions[field].header
+}
+
+// Wants reports whether any of the fields must be looked up, to be sent or to be checked by the access rules.
+func (options *Options) Wants(fields ...Field) bool {
+	return options.fields.has(fields...) || options.ruleFields.has(fields...)
+}
+
+// recordKeys converts the wanted fields in the keys of a City or Country record to decode.
+func (options *Options) recordKeys() geoip2.RecordKeys {
+	var keys geoip2.RecordKeys
+	if options.Wants(FieldContinent, FieldContinentCode) {
+		keys |= geoip2.KeyContinent
+	}
+	if options.Wants(FieldCountry, FieldCountryCode, FieldIsInEuropeanUnion, FieldRegisteredCountryMismatch, FieldCountryConfidence) {
+		keys |= geoip2.KeyCountry
+	}
+	if options.Wants(FieldRegisteredCountry, FieldRegisteredCountryCode, FieldRegisteredCountryMismatch) {
+		keys |= geoip2.KeyRegisteredCountry
+	}
+	if options.Wants(FieldRepresentedCountry, FieldRepresentedCountryCode) {
+		keys |= geoip2.KeyRepresentedCountry
+	}
+	if options.Wants(FieldRegion, FieldRegionCode, FieldSubdivisions, FieldSubdivisionCodes, FieldRegionConfidence) {
+		keys |= geoip2.KeySubdivisions
+	}
+	if options.Wants(FieldCity, FieldCityConfidence) {
+		keys |= geoip2.KeyCity
+	}
+	if options.Wants(FieldPostalCode, FieldPostalConfidence) {
+		keys |= geoip2.KeyPostal
+	}
+	if options.Wants(FieldLatitude, FieldLongitude, FieldAccuracyRadius, FieldGeohash, FieldTimeZone, FieldMetroCode) {
+		keys |= geoip2.KeyLocation
+	}
+	if options.Wants(FieldASN, FieldASNOrganization, FieldISP, FieldOrganization, FieldMobileCountryCode, FieldMobileNetworkCode,
+		FieldConnectionType, FieldDomain, FieldUserType, FieldStaticIPScore, FieldIsLegitimateProxy) {
+		keys |= geoip2.KeyTraits
+	}
+	return keys
+}
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GenericDB a DB of any type, e.g. IPinfo, ipapi or an internal one, whose record values are sent as headers.
+type GenericDB struct {
+	Path string `json:"path"`
+	// Headers header of each path in the record, e.g. "privacy.vpn" or "company.name".
+	Headers map[string]string `json:"headers"`
+}
+
+// GenericHeader header set to the value at Path in the records of a generic DB.
+type GenericHeader struct {
+	Path   []string
+	Header string
+}
+
+// ParseGenericHeaders parses the paths of the headers option of a generic DB, sorted by header.
+func ParseGenericHeaders(headers map[string]string) ([]GenericHeader, error) {
+	if len(headers) == 0 {
+		return nil, errors.New("no headers")
+	}
+	result := make([]GenericHeader, 0, len(headers))
+	for path, header := range headers {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			return nil, fmt.Errorf("empty header name for path: %q", path)
+		}
+		keys := strings.Split(strings.TrimSpace(path), ".")
+		for _, key := range keys {
+			if key == "" {
+				return nil, fmt.Errorf("invalid path: %q", path)
+			}
+		}
+		result = append(result, GenericHeader{Path: keys, Header: header})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Header < result[j].Header })
+	return result, nil
+}
+
+// validateGenericDBs checks the genericDbs option.
+func validateGenericDBs(dbs []GenericDB) error {
+	for i := range dbs {
+		if strings.TrimSpace(dbs[i].Path) == "" {
+			return errors.New("invalid genericDbs: empty path")
+		}
+		if _, err := ParseGenericHeaders(dbs[i].Headers); err != nil {
+			return fmt.Errorf("invalid genericDbs: path=%s, %w", dbs[i].Path, err)
+		}
+	}
+	return nil
+}
+
+// setGenericHeaders sets the headers from the values of a generic DB record, Unknown when a path is missing.
+func setGenericHeaders(req *http.Request, headers []GenericHeader, record interface{}) {
+	for _, header := range headers {
+		value, ok := genericValue(record, header.Path)
+		if !ok {
+			req.Header.Set(header.Header, Unknown)
+			continue
+		}
+		req.Header.Set(header.Header, formatGenericValue(value))
+	}
+}
+
+// genericValue returns the value at path in a record, a number indexes a slice and a key of the records of a
+// slice selects it in each record, e.g. "subdivisions.iso_code".
+func genericValue(value interface{}, path []string) (interface{}, bool) {
+	for i, key := range path {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			if index, err := strconv.Atoi(key); err == nil {
+				if index < 0 || index >= len(node) {
+					return nil, false
+				}
+				value = node[index]
+				continue
+			}
+			values := make([]interface{}, 0, len(node))
+			for _, item := range node {
+				if itemValue, ok := genericValue(item, path[i:]); ok {
+					values = append(values, itemValue)
+				}
+			}
+			return values, len(values) > 0
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// formatGenericValue formats a value by its type: numbers in decimal, bytes in hex, slices joined by commas
+// and maps in JSON.
+func formatGenericValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case *big.Int:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case []byte:
+		return hex.EncodeToString(v)
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = formatGenericValue(item)
+		}
+		return strings.Join(values, ",")
+	case map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return Unknown
+		}
+		return string(data)
+	default:
+		return Unknown
+	}
+}
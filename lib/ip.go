@@ -1,28 +1,187 @@
 package lib
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
 )
 
 func getClientIP(req *http.Request, options Options) string {
-	if options.IPHeader != "" {
-		return req.Header.Get(options.IPHeader)
-	} else if options.PreferXForwardedForHeader {
+	remoteAddr := normalizeIP(req.RemoteAddr)
+	if len(options.trustedProxies) > 0 && !options.isTrustedProxy(remoteAddr) {
+		// the forwarding headers can be spoofed by anyone not in the trusted proxies
+		return remoteAddr
+	}
+
+	// the first header holding a valid IP wins, e.g. CF-Connecting-IP, True-Client-IP, X-Real-IP
+	for _, header := range options.ipHeaders {
+		if ip := normalizeIP(req.Header.Get(header)); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	if options.PreferForwardedHeader {
+		// RFC 7239 Forwarded header, e.g. for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"
+		if ip := options.pickForwardedIP(parseForwarded(req.Header.Values("Forwarded"))); ip != "" {
+			return ip
+		}
+	}
+	if options.PreferXForwardedForHeader {
 		// Check X-Forwarded-For header first
-		forwardedFor := req.Header.Get("X-Forwarded-For")
-		if forwardedFor != "" {
-			ips := strings.Split(forwardedFor, ",")
-			return strings.TrimSpace(ips[0])
+		forwardedFor := req.Header.Values("X-Forwarded-For")
+		if len(forwardedFor) > 0 {
+			if ip := options.pickForwardedIP(strings.Split(strings.Join(forwardedFor, ","), ",")); ip != "" {
+				return ip
+			}
 		}
 	}
 
 	// If X-Forwarded-For is not present or retrieval is not enabled, fallback to RemoteAddr
-	remoteAddr := req.RemoteAddr
-	tmp, _, err := net.SplitHostPort(remoteAddr)
-	if err == nil {
-		remoteAddr = tmp
-	}
 	return remoteAddr
 }
+
+// toIPHeaders lists the ipHeader followed by the ipHeaders, skipping the empty and repeated ones.
+func toIPHeaders(ipHeader string, ipHeaders []string) []string {
+	result := make([]string, 0, len(ipHeaders)+1)
+	for _, header := range append([]string{ipHeader}, ipHeaders...) {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header != "" && !contains(result, header) {
+			result = append(result, header)
+		}
+	}
+	return result
+}
+
+// pickForwardedIP picks the client IP of a proxy chain, the left-most hop that is not an unknown or obfuscated node
+// when no proxies are trusted. With trusted proxies, it returns "" when the first untrusted hop is not an IP,
+// e.g. garbage or for=_hidden, so the RemoteAddr is used instead.
+func (options *Options) pickForwardedIP(ips []string) string {
+	if len(options.trustedProxies) == 0 {
+		for _, ip := range ips {
+			if !isHiddenNode(ip) {
+				return normalizeIP(ip)
+			}
+		}
+		return ""
+	}
+	if len(ips) == 0 {
+		return ""
+	}
+	if ip := options.firstUntrustedIP(ips); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return ""
+}
+
+// firstUntrustedIP walks the proxy chain from right to left and returns the first hop that is not a trusted proxy,
+// the left-most one when every hop is trusted. The hops left of one that is not an IP are not walked: they can't
+// be told apart from spoofed ones.
+func (options *Options) firstUntrustedIP(ips []string) string {
+	for i := len(ips) - 1; i >= 0; i-- {
+		ip := normalizeIP(ips[i])
+		if i == 0 || !options.isTrustedProxy(ip) {
+			return ip
+		}
+	}
+	return ""
+}
+
+func (options *Options) isTrustedProxy(ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range options.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded returns the for= node of each element of the Forwarded headers. The unknown and obfuscated nodes
+// (e.g. for=_hidden) are kept: they are hops of the chain too, and the ones left of them can't be trusted.
+func parseForwarded(values []string) []string {
+	var ips []string
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			for _, pair := range splitQuoted(element, ';') {
+				key, node, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(key), "for") {
+					continue
+				}
+				ips = append(ips, strings.Trim(strings.TrimSpace(node), `"`))
+			}
+		}
+	}
+	return ips
+}
+
+// isHiddenNode reports whether a hop is empty, unknown or obfuscated, e.g. for=unknown or for=_hidden.
+func isHiddenNode(node string) bool {
+	node = strings.TrimSpace(node)
+	return node == "" || strings.EqualFold(node, "unknown") || strings.HasPrefix(node, "_")
+}
+
+// splitQuoted splits value around sep, ignoring the separators inside quoted strings.
+func splitQuoted(value string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++
+		case sep:
+			if !quoted {
+				parts = append(parts, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, value[start:])
+}
+
+// normalizeIP strips the port, the IPv6 brackets and the zone ID of an address, e.g. "[fe80::1%eth0]:8080".
+func normalizeIP(value string) string {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	} else if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		value = value[1 : len(value)-1]
+	}
+	if i := strings.IndexByte(value, '%'); i >= 0 && strings.Contains(value, ":") {
+		value = value[:i]
+	}
+	return value
+}
+
+// parseNetworks parses CIDRs and single IPs, e.g. "10.0.0.0/8" or "2001:db8::1".
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP: %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
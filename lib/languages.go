@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// DefaultLanguage language of the place names when no languages are configured.
+const DefaultLanguage = "en"
+
+// localizedName returns the name in the first of the preferred languages the DB has.
+func (options *Options) localizedName(names map[string]string) string {
+	for _, language := range options.languages() {
+		if name, ok := names[language]; ok && name != "" {
+			return name
+		}
+	}
+	return Unknown
+}
+
+// localizedNames returns the name in each preferred language, nil when the per-language headers are disabled.
+func (options *Options) localizedNames(names map[string]string) []string {
+	if !options.LanguageHeaders {
+		return nil
+	}
+	languages := options.languages()
+	result := make([]string, len(languages))
+	for i, language := range languages {
+		result[i] = valueOrUnknown(names[language])
+	}
+	return result
+}
+
+// setLocalizedHeaders sets one header per preferred language, e.g. GeoIP-City-pt-BR, a nil names sets them Unknown.
+func (options *Options) setLocalizedHeaders(req *http.Request, field Field, names []string) {
+	if !options.LanguageHeaders || !options.fields.has(field) {
+		return
+	}
+	for i, language := range options.languages() {
+		value := Unknown
+		if names != nil {
+			value = names[i]
+		}
+		req.Header.Set(options.headerName(field)+"-"+language, value)
+	}
+}
+
+// checkLanguages logs the configured languages the DB metadata doesn't list, the metadata languages are optional.
+func (options *Options) checkLanguages(available []string, dbPath, name string) {
+	if len(available) == 0 {
+		return
+	}
+	for _, language := range options.Languages {
+		if !contains(available, language) {
+			log.Printf("[geoip2] Language not found in DB, falling back to the next one: db=%s, name=%s, language=%s, available=%s",
+				dbPath, name, language, strings.Join(available, ","))
+		}
+	}
+}
+
+func (options *Options) languages() []string {
+	if len(options.Languages) == 0 {
+		return []string{DefaultLanguage}
+	}
+	return options.Languages
+}
+
+// toLanguages trims the configured languages, keeping their case as the DB locale codes are case sensitive.
+func toLanguages(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
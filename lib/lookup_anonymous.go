@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
+)
+
+// GeoIPAnonymousResult flags of an IP address found in the Anonymous-IP database.
+type GeoIPAnonymousResult struct {
+	isAnonymous        bool
+	isAnonymousVPN     bool
+	isHostingProvider  bool
+	isPublicProxy      bool
+	isTorExitNode      bool
+	isResidentialProxy bool
+}
+
+// LookupGeoIPAnonymous LookupGeoIPAnonymous.
+type LookupGeoIPAnonymous func(ip net.IP) (*GeoIPAnonymousResult, error)
+
+// CreateAnonymousDBLookup CreateAnonymousDBLookup.
+func CreateAnonymousDBLookup(rdr *geoip2.AnonymousIPReader) LookupGeoIPAnonymous {
+	return func(ip net.IP) (*GeoIPAnonymousResult, error) {
+		rec, err := rdr.Lookup(ip)
+		if errors.Is(err, geoip2.ErrNotFound) {
+			// the database only lists anonymous networks
+			return &GeoIPAnonymousResult{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		returnVal := GeoIPAnonymousResult{
+			isAnonymous:        rec.IsAnonymous,
+			isAnonymousVPN:     rec.IsAnonymousVPN,
+			isHostingProvider:  rec.IsHostingProvider,
+			isPublicProxy:      rec.IsPublicProxy,
+			isTorExitNode:      rec.IsTorExitNode,
+			isResidentialProxy: rec.IsResidentialProxy,
+		}
+		return &returnVal, nil
+	}
+}
+
+// CreateAnonymousDBLookupIso88591 CreateAnonymousDBLookup.
+func CreateAnonymousDBLookupIso88591(rdr *geoip2_iso88591.AnonymousIPReader) LookupGeoIPAnonymous {
+	return func(ip net.IP) (*GeoIPAnonymousResult, error) {
+		rec, err := rdr.Lookup(ip)
+		if errors.Is(err, geoip2_iso88591.ErrNotFound) {
+			// the database only lists anonymous networks
+			return &GeoIPAnonymousResult{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		returnVal := GeoIPAnonymousResult{
+			isAnonymous:        rec.IsAnonymous,
+			isAnonymousVPN:     rec.IsAnonymousVPN,
+			isHostingProvider:  rec.IsHostingProvider,
+			isPublicProxy:      rec.IsPublicProxy,
+			isTorExitNode:      rec.IsTorExitNode,
+			isResidentialProxy: rec.IsResidentialProxy,
+		}
+		return &returnVal, nil
+	}
+}
+
+// NewLookupAnonymous Create a new Lookup with the default options, its DB is shared with the other middleware instances.
+func NewLookupAnonymous(dbPath, name string, iso88591 bool) (LookupGeoIPAnonymous, error) {
+	options := ConfigToOptions(&Config{Iso88591: iso88591})
+	lookup, _, err := newLookupAnonymous(dbPath, name, &options)
+	return lookup, err
+}
+
+// newLookupAnonymous creates a Lookup over the DB shared by the middleware instances, release drops its reference.
+func newLookupAnonymous(dbPath, name string, options *Options) (LookupGeoIPAnonymous, func(), error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil, fmt.Errorf("anonymous ip DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
+	}
+	var lookupAnonymous LookupGeoIPAnonymous
+	var release func()
+
+	if options.Iso88591 {
+		shared, releaseDB, err := openSharedDB("anonymous", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2_iso88591.NewAnonymousIPReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("anonymous ip lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2_iso88591.AnonymousIPReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupAnonymous = CreateAnonymousDBLookupIso88591(rdr)
+	} else {
+		shared, releaseDB, err := openSharedDB("anonymous", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2.NewAnonymousIPReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("anonymous ip lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2.AnonymousIPReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupAnonymous = CreateAnonymousDBLookup(rdr)
+	}
+	return lookupAnonymous, release, nil
+}
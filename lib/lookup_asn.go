@@ -10,10 +10,13 @@ import (
 	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
 )
 
-// GeoIPAsnResult in memory, this should have between 126 and 180 bytes. On average, consider 150 bytes.
+// GeoIPAsnResult in memory, the struct takes 56 bytes on 64-bit platforms, plus the text of its values.
 type GeoIPAsnResult struct {
 	number       string
 	organization string
+	source       string
+	// isp is the ISP DB result the ASN was looked up in, nil when the ISP DB is not in the ASN chain
+	isp *GeoIPIspResult
 }
 
 // LookupGeoIPAsn LookupGeoIP.
@@ -21,6 +24,7 @@ type LookupGeoIPAsn func(ip net.IP) (*GeoIPAsnResult, error)
 
 // CreateAsnDBLookup CreateCountryDBLookup.
 func CreateAsnDBLookup(rdr *geoip2.ASNReader) LookupGeoIPAsn {
+	source := rdr.Metadata().DatabaseType
 	return func(ip net.IP) (*GeoIPAsnResult, error) {
 		rec, err := rdr.Lookup(ip)
 		if err != nil {
@@ -29,6 +33,7 @@ func CreateAsnDBLookup(rdr *geoip2.ASNReader) LookupGeoIPAsn {
 		returnVal := GeoIPAsnResult{
 			number:       strconv.Itoa(int(rec.AutonomousSystemNumber)),
 			organization: rec.AutonomousSystemOrganization,
+			source:       source,
 		}
 		return &returnVal, nil
 	}
@@ -36,6 +41,7 @@ func CreateAsnDBLookup(rdr *geoip2.ASNReader) LookupGeoIPAsn {
 
 // CreateAsnDBLookupIso88591 CreateCountryDBLookup.
 func CreateAsnDBLookupIso88591(rdr *geoip2_iso88591.ASNReader) LookupGeoIPAsn {
+	source := rdr.Metadata().DatabaseType
 	return func(ip net.IP) (*GeoIPAsnResult, error) {
 		rec, err := rdr.Lookup(ip)
 		if err != nil {
@@ -44,31 +50,50 @@ func CreateAsnDBLookupIso88591(rdr *geoip2_iso88591.ASNReader) LookupGeoIPAsn {
 		returnVal := GeoIPAsnResult{
 			number:       strconv.Itoa(int(rec.AutonomousSystemNumber)),
 			organization: rec.AutonomousSystemOrganization,
+			source:       source,
 		}
 		return &returnVal, nil
 	}
 }
 
-// NewLookupAsn Create a new Lookup.
+// NewLookupAsn Create a new Lookup with the default options, its DB is shared with the other middleware instances.
 func NewLookupAsn(dbPath, name string, iso88591 bool) (LookupGeoIPAsn, error) {
+	options := ConfigToOptions(&Config{Iso88591: iso88591})
+	lookup, _, err := newLookupAsn(dbPath, name, &options)
+	return lookup, err
+}
+
+// newLookupAsn creates a Lookup over the DB shared by the middleware instances, release drops its reference.
+func newLookupAsn(dbPath, name string, options *Options) (LookupGeoIPAsn, func(), error) {
 	if _, err := os.Stat(dbPath); err != nil {
-		return nil, fmt.Errorf("asn DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
+		return nil, nil, fmt.Errorf("asn DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
 	}
 	var lookupAsn LookupGeoIPAsn
+	var release func()
 
-	if iso88591 {
-		rdr, err := geoip2_iso88591.NewASNReaderFromFile(dbPath)
+	if options.Iso88591 {
+		shared, releaseDB, err := openSharedDB("asn", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2_iso88591.NewASNReader(buffer)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("asn lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+			return nil, nil, fmt.Errorf("asn lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
 		}
+		rdr := shared.(*geoip2_iso88591.ASNReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
 		lookupAsn = CreateAsnDBLookupIso88591(rdr)
 	} else {
-		rdr, err := geoip2.NewASNReaderFromFile(dbPath)
+		shared, releaseDB, err := openSharedDB("asn", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2.NewASNReader(buffer)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("asn lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+			return nil, nil, fmt.Errorf("asn lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
 		}
+		rdr := shared.(*geoip2.ASNReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
 		lookupAsn = CreateAsnDBLookup(rdr)
 	}
 	// log.Printf("[geoip2] ASN lookup DB initialized: db=%s, name=%s, lookup=%v", dbPath, name, lookupAsn)
-	return lookupAsn, nil
+	return lookupAsn, release, nil
 }
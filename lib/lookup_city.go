@@ -5,23 +5,30 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
 
 	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
 	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
 )
 
-// GeoIPCityResult in memory, this should have between 126 and 180 bytes. On average, consider 150 bytes.
+// GeoIPCityResult in memory, the struct takes 448 bytes on 64-bit platforms, plus the text of its values.
 type GeoIPCityResult struct {
-	country        string
-	countryCode    string
-	region         string
-	regionCode     string
-	city           string
-	latitude       string
-	longitude      string
-	accuracyRadius string
-	geohash        string
-	postalCode     string
+	GeoIPCountryResult
+	region           string
+	regionCode       string
+	subdivisions     string
+	subdivisionCodes string
+	city             string
+	postalCode       string
+	latitude         string
+	longitude        string
+	accuracyRadius   string
+	geohash          string
+	timeZone         string
+	metroCode        string
+	regionNames      []string
+	cityNames        []string
+	enterprise       *GeoIPEnterpriseResult
 }
 
 const kmToMeters = 1000
@@ -29,96 +36,174 @@ const kmToMeters = 1000
 // LookupGeoIPCity LookupGeoIP.
 type LookupGeoIPCity func(ip net.IP) (*GeoIPCityResult, error)
 
-// CreateCityDBLookup CreateCityDBLookup.
+// CreateCityDBLookup CreateCityDBLookup, every field is decoded.
 func CreateCityDBLookup(rdr *geoip2.CityReader) LookupGeoIPCity {
+	return createCityDBLookup(rdr, &Options{})
+}
+
+// createCityDBLookup only decodes the fields wanted by options.
+func createCityDBLookup(rdr *geoip2.CityReader, options *Options) LookupGeoIPCity {
+	keys := options.recordKeys()
+	source := rdr.Metadata().DatabaseType
+	enterprise := source == EnterpriseDatabaseType && options.Wants(enterpriseFields...)
+	location := options.Wants(FieldLatitude, FieldLongitude, FieldAccuracyRadius, FieldMetroCode)
+	geohash := options.Wants(FieldGeohash)
+	subdivisions := options.Wants(FieldSubdivisions, FieldSubdivisionCodes)
 	return func(ip net.IP) (*GeoIPCityResult, error) {
-		rec, err := rdr.Lookup(ip)
+		rec, err := rdr.LookupKeys(ip, keys)
 		if err != nil {
 			return nil, fmt.Errorf("%w", err)
 		}
 		returnVal := GeoIPCityResult{
-			country:        Unknown,
-			countryCode:    rec.Country.ISOCode,
-			region:         Unknown,
-			regionCode:     Unknown,
-			city:           Unknown,
-			postalCode:     rec.Postal.Code,
-			latitude:       strconv.FormatFloat(rec.Location.Latitude, 'f', -1, 64),
-			longitude:      strconv.FormatFloat(rec.Location.Longitude, 'f', -1, 64),
-			accuracyRadius: strconv.Itoa(int(rec.Location.AccuracyRadius) * kmToMeters),
-			geohash:        EncodeGeoHash(rec.Location.Latitude, rec.Location.Longitude),
+			GeoIPCountryResult: newCountryResult(options, &rec.Continent, &rec.Country, &rec.RegisteredCountry, &rec.RepresentedCountry),
+			region:             Unknown,
+			regionCode:         Unknown,
+			subdivisions:       Unknown,
+			subdivisionCodes:   Unknown,
+			city:               options.localizedName(rec.City.Names),
+			postalCode:         rec.Postal.Code,
+			timeZone:           valueOrUnknown(rec.Location.TimeZone),
+			metroCode:          Unknown,
+			cityNames:          options.localizedNames(rec.City.Names),
 		}
-		if country, ok := rec.Country.Names["en"]; ok {
-			returnVal.country = country
+		if location {
+			returnVal.latitude = strconv.FormatFloat(rec.Location.Latitude, 'f', -1, 64)
+			returnVal.longitude = strconv.FormatFloat(rec.Location.Longitude, 'f', -1, 64)
+			returnVal.accuracyRadius = strconv.Itoa(int(rec.Location.AccuracyRadius) * kmToMeters)
+			if rec.Location.MetroCode != 0 {
+				returnVal.metroCode = strconv.Itoa(int(rec.Location.MetroCode))
+			}
 		}
-		if city, ok := rec.City.Names["en"]; ok {
-			returnVal.city = city
+		if geohash {
+			returnVal.geohash = EncodeGeoHash(rec.Location.Latitude, rec.Location.Longitude)
 		}
-		if rec.Subdivisions != nil {
-			if region, ok := rec.Subdivisions[0].Names["en"]; ok {
-				returnVal.region = region
+		if len(rec.Subdivisions) > 0 {
+			returnVal.region = options.localizedName(rec.Subdivisions[0].Names)
+			returnVal.regionCode = valueOrUnknown(rec.Subdivisions[0].ISOCode)
+			returnVal.regionNames = options.localizedNames(rec.Subdivisions[0].Names)
+			if subdivisions {
+				names := make([]string, len(rec.Subdivisions))
+				codes := make([]string, len(rec.Subdivisions))
+				for i := range rec.Subdivisions {
+					names[i] = options.localizedName(rec.Subdivisions[i].Names)
+					codes[i] = valueOrUnknown(rec.Subdivisions[i].ISOCode)
+				}
+				returnVal.subdivisions = strings.Join(names, ",")
+				returnVal.subdivisionCodes = strings.Join(codes, ",")
 			}
-			returnVal.regionCode = rec.Subdivisions[0].ISOCode
+		}
+		returnVal.source = source
+		if enterprise {
+			returnVal.enterprise = newEnterpriseResult(rec)
 		}
 		return &returnVal, nil
 	}
 }
 
-// CreateCityDBLookupIso88591 CreateCityDBLookup.
+// CreateCityDBLookupIso88591 CreateCityDBLookup, every field is decoded.
 func CreateCityDBLookupIso88591(rdr *geoip2_iso88591.CityReader) LookupGeoIPCity {
+	return createCityDBLookupIso88591(rdr, &Options{})
+}
+
+// createCityDBLookupIso88591 only decodes the fields wanted by options.
+func createCityDBLookupIso88591(rdr *geoip2_iso88591.CityReader, options *Options) LookupGeoIPCity {
+	keys := geoip2_iso88591.RecordKeys(options.recordKeys())
+	source := rdr.Metadata().DatabaseType
+	enterprise := source == EnterpriseDatabaseType && options.Wants(enterpriseFields...)
+	location := options.Wants(FieldLatitude, FieldLongitude, FieldAccuracyRadius, FieldMetroCode)
+	geohash := options.Wants(FieldGeohash)
+	subdivisions := options.Wants(FieldSubdivisions, FieldSubdivisionCodes)
 	return func(ip net.IP) (*GeoIPCityResult, error) {
-		rec, err := rdr.Lookup(ip)
+		rec, err := rdr.LookupKeys(ip, keys)
 		if err != nil {
 			return nil, fmt.Errorf("%w", err)
 		}
 		returnVal := GeoIPCityResult{
-			country:        Unknown,
-			countryCode:    rec.Country.ISOCode,
-			region:         Unknown,
-			regionCode:     Unknown,
-			city:           Unknown,
-			postalCode:     rec.Postal.Code,
-			latitude:       strconv.FormatFloat(rec.Location.Latitude, 'f', -1, 64),
-			longitude:      strconv.FormatFloat(rec.Location.Longitude, 'f', -1, 64),
-			accuracyRadius: strconv.Itoa(int(rec.Location.AccuracyRadius) * kmToMeters),
-			geohash:        EncodeGeoHash(rec.Location.Latitude, rec.Location.Longitude),
+			GeoIPCountryResult: newCountryResultIso88591(options, &rec.Continent, &rec.Country, &rec.RegisteredCountry, &rec.RepresentedCountry),
+			region:             Unknown,
+			regionCode:         Unknown,
+			subdivisions:       Unknown,
+			subdivisionCodes:   Unknown,
+			city:               options.localizedName(rec.City.Names),
+			postalCode:         rec.Postal.Code,
+			timeZone:           valueOrUnknown(rec.Location.TimeZone),
+			metroCode:          Unknown,
+			cityNames:          options.localizedNames(rec.City.Names),
 		}
-		if country, ok := rec.Country.Names["en"]; ok {
-			returnVal.country = country
+		if location {
+			returnVal.latitude = strconv.FormatFloat(rec.Location.Latitude, 'f', -1, 64)
+			returnVal.longitude = strconv.FormatFloat(rec.Location.Longitude, 'f', -1, 64)
+			returnVal.accuracyRadius = strconv.Itoa(int(rec.Location.AccuracyRadius) * kmToMeters)
+			if rec.Location.MetroCode != 0 {
+				returnVal.metroCode = strconv.Itoa(int(rec.Location.MetroCode))
+			}
 		}
-		if city, ok := rec.City.Names["en"]; ok {
-			returnVal.city = city
+		if geohash {
+			returnVal.geohash = EncodeGeoHash(rec.Location.Latitude, rec.Location.Longitude)
 		}
-		if rec.Subdivisions != nil {
-			if region, ok := rec.Subdivisions[0].Names["en"]; ok {
-				returnVal.region = region
+		if len(rec.Subdivisions) > 0 {
+			returnVal.region = options.localizedName(rec.Subdivisions[0].Names)
+			returnVal.regionCode = valueOrUnknown(rec.Subdivisions[0].ISOCode)
+			returnVal.regionNames = options.localizedNames(rec.Subdivisions[0].Names)
+			if subdivisions {
+				names := make([]string, len(rec.Subdivisions))
+				codes := make([]string, len(rec.Subdivisions))
+				for i := range rec.Subdivisions {
+					names[i] = options.localizedName(rec.Subdivisions[i].Names)
+					codes[i] = valueOrUnknown(rec.Subdivisions[i].ISOCode)
+				}
+				returnVal.subdivisions = strings.Join(names, ",")
+				returnVal.subdivisionCodes = strings.Join(codes, ",")
 			}
-			returnVal.regionCode = rec.Subdivisions[0].ISOCode
+		}
+		returnVal.source = source
+		if enterprise {
+			returnVal.enterprise = newEnterpriseResultIso88591(rec)
 		}
 		return &returnVal, nil
 	}
 }
 
-// NewLookupCity Create a new Lookup.
+// NewLookupCity Create a new Lookup with the default options, its DB is shared with the other middleware instances.
 func NewLookupCity(dbPath, name string, iso88591 bool) (LookupGeoIPCity, error) {
+	options := ConfigToOptions(&Config{Iso88591: iso88591})
+	lookup, _, err := newLookupCity(dbPath, name, &options)
+	return lookup, err
+}
+
+// newLookupCity creates a Lookup over the DB shared by the middleware instances, release drops its reference.
+func newLookupCity(dbPath, name string, options *Options) (LookupGeoIPCity, func(), error) {
 	if _, err := os.Stat(dbPath); err != nil {
-		return nil, fmt.Errorf("city DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
+		return nil, nil, fmt.Errorf("city DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
 	}
 	var lookupCity LookupGeoIPCity
+	var release func()
 
-	if iso88591 {
-		rdr, err := geoip2_iso88591.NewCityReaderFromFile(dbPath)
+	if options.Iso88591 {
+		shared, releaseDB, err := openSharedDB("city", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2_iso88591.NewCityReader(buffer)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("city lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+			return nil, nil, fmt.Errorf("city lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
 		}
-		lookupCity = CreateCityDBLookupIso88591(rdr)
+		rdr := shared.(*geoip2_iso88591.CityReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		options.checkLanguages(rdr.Metadata().Languages, dbPath, name)
+		lookupCity = createCityDBLookupIso88591(rdr, options)
 	} else {
-		rdr, err := geoip2.NewCityReaderFromFile(dbPath)
+		shared, releaseDB, err := openSharedDB("city", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2.NewCityReader(buffer)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("city lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+			return nil, nil, fmt.Errorf("city lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
 		}
-		lookupCity = CreateCityDBLookup(rdr)
+		rdr := shared.(*geoip2.CityReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		options.checkLanguages(rdr.Metadata().Languages, dbPath, name)
+		lookupCity = createCityDBLookup(rdr, options)
 	}
 	// log.Printf("[geoip2] City lookup DB initialized: db=%s, name=%s, lookup=%v", dbPath, name, lookupCity)
-	return lookupCity, nil
+	return lookupCity, release, nil
 }
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
+)
+
+// LookupGeoIPConnectionType LookupGeoIPConnectionType.
+type LookupGeoIPConnectionType func(ip net.IP) (string, error)
+
+// CreateConnectionTypeDBLookup CreateConnectionTypeDBLookup.
+func CreateConnectionTypeDBLookup(rdr *geoip2.ConnectionTypeReader) LookupGeoIPConnectionType {
+	return func(ip net.IP) (string, error) {
+		connectionType, err := rdr.Lookup(ip)
+		if err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		return connectionType, nil
+	}
+}
+
+// CreateConnectionTypeDBLookupIso88591 CreateConnectionTypeDBLookup.
+func CreateConnectionTypeDBLookupIso88591(rdr *geoip2_iso88591.ConnectionTypeReader) LookupGeoIPConnectionType {
+	return func(ip net.IP) (string, error) {
+		connectionType, err := rdr.Lookup(ip)
+		if err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		return connectionType, nil
+	}
+}
+
+// NewLookupConnectionType Create a new Lookup with the default options, its DB is shared with the other middleware instances.
+func NewLookupConnectionType(dbPath, name string, iso88591 bool) (LookupGeoIPConnectionType, error) {
+	options := ConfigToOptions(&Config{Iso88591: iso88591})
+	lookup, _, err := newLookupConnectionType(dbPath, name, &options)
+	return lookup, err
+}
+
+// newLookupConnectionType creates a Lookup over the DB shared by the middleware instances, release drops its reference.
+func newLookupConnectionType(dbPath, name string, options *Options) (LookupGeoIPConnectionType, func(), error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil, fmt.Errorf("connection type DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
+	}
+	var lookupConnectionType LookupGeoIPConnectionType
+	var release func()
+
+	if options.Iso88591 {
+		shared, releaseDB, err := openSharedDB("connection-type", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2_iso88591.NewConnectionTypeReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("connection type lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2_iso88591.ConnectionTypeReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupConnectionType = CreateConnectionTypeDBLookupIso88591(rdr)
+	} else {
+		shared, releaseDB, err := openSharedDB("connection-type", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2.NewConnectionTypeReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("connection type lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2.ConnectionTypeReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupConnectionType = CreateConnectionTypeDBLookup(rdr)
+	}
+	return lookupConnectionType, release, nil
+}
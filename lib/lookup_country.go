@@ -4,76 +4,155 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 
 	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
 	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
 )
 
-// GeoIPCountryResult in memory, this should have between 126 and 180 bytes. On average, consider 150 bytes.
+// GeoIPCountryResult in memory, the struct takes 200 bytes on 64-bit platforms, plus the text of its values.
 type GeoIPCountryResult struct {
-	country     string
-	countryCode string
+	country                   string
+	countryCode               string
+	continent                 string
+	continentCode             string
+	isInEuropeanUnion         string
+	registeredCountry         string
+	registeredCountryCode     string
+	representedCountry        string
+	representedCountryCode    string
+	registeredCountryMismatch string
+	countryNames              []string
+	source                    string
 }
 
 // LookupGeoIPCountry LookupGeoIPCountry.
 type LookupGeoIPCountry func(ip net.IP) (*GeoIPCountryResult, error)
 
-// CreateCountryDBLookup CreateCountryDBLookup.
+func newCountryResult(options *Options, continent *geoip2.Continent, country, registered, represented *geoip2.Country) GeoIPCountryResult {
+	return GeoIPCountryResult{
+		country:                   options.localizedName(country.Names),
+		countryCode:               country.ISOCode,
+		continent:                 options.localizedName(continent.Names),
+		continentCode:             valueOrUnknown(continent.Code),
+		isInEuropeanUnion:         strconv.FormatBool(country.IsInEuropeanUnion),
+		registeredCountry:         options.localizedName(registered.Names),
+		registeredCountryCode:     valueOrUnknown(registered.ISOCode),
+		representedCountry:        options.localizedName(represented.Names),
+		representedCountryCode:    valueOrUnknown(represented.ISOCode),
+		registeredCountryMismatch: strconv.FormatBool(isCountryMismatch(country.ISOCode, registered.ISOCode)),
+		countryNames:              options.localizedNames(country.Names),
+	}
+}
+
+func newCountryResultIso88591(options *Options, continent *geoip2_iso88591.Continent, country, registered, represented *geoip2_iso88591.Country) GeoIPCountryResult {
+	return GeoIPCountryResult{
+		country:                   options.localizedName(country.Names),
+		countryCode:               country.ISOCode,
+		continent:                 options.localizedName(continent.Names),
+		continentCode:             valueOrUnknown(continent.Code),
+		isInEuropeanUnion:         strconv.FormatBool(country.IsInEuropeanUnion),
+		registeredCountry:         options.localizedName(registered.Names),
+		registeredCountryCode:     valueOrUnknown(registered.ISOCode),
+		representedCountry:        options.localizedName(represented.Names),
+		representedCountryCode:    valueOrUnknown(represented.ISOCode),
+		registeredCountryMismatch: strconv.FormatBool(isCountryMismatch(country.ISOCode, registered.ISOCode)),
+		countryNames:              options.localizedNames(country.Names),
+	}
+}
+
+// CreateCountryDBLookup CreateCountryDBLookup, every field is decoded.
 func CreateCountryDBLookup(rdr *geoip2.CountryReader) LookupGeoIPCountry {
+	return createCountryDBLookup(rdr, &Options{})
+}
+
+// createCountryDBLookup only decodes the fields wanted by options.
+func createCountryDBLookup(rdr *geoip2.CountryReader, options *Options) LookupGeoIPCountry {
+	keys := options.recordKeys()
+	source := rdr.Metadata().DatabaseType
 	return func(ip net.IP) (*GeoIPCountryResult, error) {
-		rec, err := rdr.Lookup(ip)
+		rec, err := rdr.LookupKeys(ip, keys)
 		if err != nil {
 			return nil, fmt.Errorf("%w", err)
 		}
-		returnVal := GeoIPCountryResult{
-			country:     Unknown,
-			countryCode: rec.Country.ISOCode,
-		}
-		if country, ok := rec.Country.Names["en"]; ok {
-			returnVal.country = country
-		}
+		returnVal := newCountryResult(options, &rec.Continent, &rec.Country, &rec.RegisteredCountry, &rec.RepresentedCountry)
+		returnVal.source = source
 		return &returnVal, nil
 	}
 }
 
-// CreateCountryDBLookupIso88591 CreateCountryDBLookup.
+// CreateCountryDBLookupIso88591 CreateCountryDBLookup, every field is decoded.
 func CreateCountryDBLookupIso88591(rdr *geoip2_iso88591.CountryReader) LookupGeoIPCountry {
+	return createCountryDBLookupIso88591(rdr, &Options{})
+}
+
+// createCountryDBLookupIso88591 only decodes the fields wanted by options.
+func createCountryDBLookupIso88591(rdr *geoip2_iso88591.CountryReader, options *Options) LookupGeoIPCountry {
+	keys := geoip2_iso88591.RecordKeys(options.recordKeys())
+	source := rdr.Metadata().DatabaseType
 	return func(ip net.IP) (*GeoIPCountryResult, error) {
-		rec, err := rdr.Lookup(ip)
+		rec, err := rdr.LookupKeys(ip, keys)
 		if err != nil {
 			return nil, fmt.Errorf("%w", err)
 		}
-		returnVal := GeoIPCountryResult{
-			country:     Unknown,
-			countryCode: rec.Country.ISOCode,
-		}
-		if country, ok := rec.Country.Names["en"]; ok {
-			returnVal.country = country
-		}
+		returnVal := newCountryResultIso88591(options, &rec.Continent, &rec.Country, &rec.RegisteredCountry, &rec.RepresentedCountry)
+		returnVal.source = source
 		return &returnVal, nil
 	}
 }
 
-// NewLookupCountry Create a new Lookup.
+// NewLookupCountry Create a new Lookup with the default options, its DB is shared with the other middleware instances.
 func NewLookupCountry(dbPath, name string, iso88591 bool) (LookupGeoIPCountry, error) {
+	options := ConfigToOptions(&Config{Iso88591: iso88591})
+	lookup, _, err := newLookupCountry(dbPath, name, &options)
+	return lookup, err
+}
+
+// newLookupCountry creates a Lookup over the DB shared by the middleware instances, release drops its reference.
+func newLookupCountry(dbPath, name string, options *Options) (LookupGeoIPCountry, func(), error) {
 	if _, err := os.Stat(dbPath); err != nil {
-		return nil, fmt.Errorf("country DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
+		return nil, nil, fmt.Errorf("country DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
 	}
 	var lookupCountry LookupGeoIPCountry
+	var release func()
 
-	if iso88591 {
-		rdr, err := geoip2_iso88591.NewCountryReaderFromFile(dbPath)
+	if options.Iso88591 {
+		shared, releaseDB, err := openSharedDB("country", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2_iso88591.NewCountryReader(buffer)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("country lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+			return nil, nil, fmt.Errorf("country lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
 		}
-		lookupCountry = CreateCountryDBLookupIso88591(rdr)
+		rdr := shared.(*geoip2_iso88591.CountryReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		options.checkLanguages(rdr.Metadata().Languages, dbPath, name)
+		lookupCountry = createCountryDBLookupIso88591(rdr, options)
 	} else {
-		rdr, err := geoip2.NewCountryReaderFromFile(dbPath)
+		shared, releaseDB, err := openSharedDB("country", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2.NewCountryReader(buffer)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("country lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+			return nil, nil, fmt.Errorf("country lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
 		}
-		lookupCountry = CreateCountryDBLookup(rdr)
+		rdr := shared.(*geoip2.CountryReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		options.checkLanguages(rdr.Metadata().Languages, dbPath, name)
+		lookupCountry = createCountryDBLookup(rdr, options)
 	}
 	// log.Printf("[geoip2] Country lookup DB initialized: db=%s, name=%s, lookup=%v", dbPath, name, lookupCountry)
-	return lookupCountry, nil
+	return lookupCountry, release, nil
+}
+
+// isCountryMismatch reports whether the IP is registered to another country than the one it is located in.
+func isCountryMismatch(countryCode, registeredCountryCode string) bool {
+	return countryCode != "" && registeredCountryCode != "" && countryCode != registeredCountryCode
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return Unknown
+	}
+	return value
 }
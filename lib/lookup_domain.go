@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
+)
+
+// LookupGeoIPDomain LookupGeoIPDomain.
+type LookupGeoIPDomain func(ip net.IP) (string, error)
+
+// CreateDomainDBLookup CreateDomainDBLookup.
+func CreateDomainDBLookup(rdr *geoip2.DomainReader) LookupGeoIPDomain {
+	return func(ip net.IP) (string, error) {
+		domain, err := rdr.Lookup(ip)
+		if err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		return domain, nil
+	}
+}
+
+// CreateDomainDBLookupIso88591 CreateDomainDBLookup.
+func CreateDomainDBLookupIso88591(rdr *geoip2_iso88591.DomainReader) LookupGeoIPDomain {
+	return func(ip net.IP) (string, error) {
+		domain, err := rdr.Lookup(ip)
+		if err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		return domain, nil
+	}
+}
+
+// NewLookupDomain Create a new Lookup with the default options, its DB is shared with the other middleware instances.
+func NewLookupDomain(dbPath, name string, iso88591 bool) (LookupGeoIPDomain, error) {
+	options := ConfigToOptions(&Config{Iso88591: iso88591})
+	lookup, _, err := newLookupDomain(dbPath, name, &options)
+	return lookup, err
+}
+
+// newLookupDomain creates a Lookup over the DB shared by the middleware instances, release drops its reference.
+func newLookupDomain(dbPath, name string, options *Options) (LookupGeoIPDomain, func(), error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil, fmt.Errorf("domain DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
+	}
+	var lookupDomain LookupGeoIPDomain
+	var release func()
+
+	if options.Iso88591 {
+		shared, releaseDB, err := openSharedDB("domain", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2_iso88591.NewDomainReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("domain lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2_iso88591.DomainReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupDomain = CreateDomainDBLookupIso88591(rdr)
+	} else {
+		shared, releaseDB, err := openSharedDB("domain", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2.NewDomainReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("domain lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2.DomainReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupDomain = CreateDomainDBLookup(rdr)
+	}
+	return lookupDomain, release, nil
+}
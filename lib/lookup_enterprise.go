@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"strconv"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
+)
+
+// EnterpriseDatabaseType database type of the GeoIP2 Enterprise DB.
+const EnterpriseDatabaseType = "GeoIP2-Enterprise"
+
+// GeoIPEnterpriseResult fields only filled by the GeoIP2 Enterprise DB.
+type GeoIPEnterpriseResult struct {
+	countryConfidence string
+	regionConfidence  string
+	cityConfidence    string
+	postalConfidence  string
+	userType          string
+	staticIPScore     string
+	isp               string
+	organization      string
+	connectionType    string
+	domain            string
+	isLegitimateProxy string
+	mobileCountryCode string
+	mobileNetworkCode string
+	asn               GeoIPAsnResult
+}
+
+func newEnterpriseResult(rec *geoip2.CityResult) *GeoIPEnterpriseResult {
+	returnVal := GeoIPEnterpriseResult{
+		countryConfidence: numberOrUnknown(int(rec.Country.Confidence)),
+		regionConfidence:  Unknown,
+		cityConfidence:    numberOrUnknown(int(rec.City.Confidence)),
+		postalConfidence:  numberOrUnknown(int(rec.Postal.Confidence)),
+		userType:          valueOrUnknown(rec.Traits.UserType),
+		staticIPScore:     scoreOrUnknown(rec.Traits.StaticIPScore),
+		isp:               valueOrUnknown(rec.Traits.ISP),
+		organization:      valueOrUnknown(rec.Traits.Organization),
+		connectionType:    valueOrUnknown(rec.Traits.ConnectionType),
+		domain:            valueOrUnknown(rec.Traits.Domain),
+		isLegitimateProxy: strconv.FormatBool(rec.Traits.IsLegitimateProxy),
+		mobileCountryCode: valueOrUnknown(rec.Traits.MobileCountryCode),
+		mobileNetworkCode: valueOrUnknown(rec.Traits.MobileNetworkCode),
+		asn: GeoIPAsnResult{
+			number:       numberOrUnknown(int(rec.Traits.AutonomousSystemNumber)),
+			organization: valueOrUnknown(rec.Traits.AutonomousSystemOrganization),
+			source:       EnterpriseDatabaseType,
+		},
+	}
+	if len(rec.Subdivisions) > 0 {
+		returnVal.regionConfidence = numberOrUnknown(int(rec.Subdivisions[0].Confidence))
+	}
+	return &returnVal
+}
+
+func newEnterpriseResultIso88591(rec *geoip2_iso88591.CityResult) *GeoIPEnterpriseResult {
+	returnVal := GeoIPEnterpriseResult{
+		countryConfidence: numberOrUnknown(int(rec.Country.Confidence)),
+		regionConfidence:  Unknown,
+		cityConfidence:    numberOrUnknown(int(rec.City.Confidence)),
+		postalConfidence:  numberOrUnknown(int(rec.Postal.Confidence)),
+		userType:          valueOrUnknown(rec.Traits.UserType),
+		staticIPScore:     scoreOrUnknown(rec.Traits.StaticIPScore),
+		isp:               valueOrUnknown(rec.Traits.ISP),
+		organization:      valueOrUnknown(rec.Traits.Organization),
+		connectionType:    valueOrUnknown(rec.Traits.ConnectionType),
+		domain:            valueOrUnknown(rec.Traits.Domain),
+		isLegitimateProxy: strconv.FormatBool(rec.Traits.IsLegitimateProxy),
+		mobileCountryCode: valueOrUnknown(rec.Traits.MobileCountryCode),
+		mobileNetworkCode: valueOrUnknown(rec.Traits.MobileNetworkCode),
+		asn: GeoIPAsnResult{
+			number:       numberOrUnknown(int(rec.Traits.AutonomousSystemNumber)),
+			organization: valueOrUnknown(rec.Traits.AutonomousSystemOrganization),
+			source:       EnterpriseDatabaseType,
+		},
+	}
+	if len(rec.Subdivisions) > 0 {
+		returnVal.regionConfidence = numberOrUnknown(int(rec.Subdivisions[0].Confidence))
+	}
+	return &returnVal
+}
+
+// numberOrUnknown returns Unknown for a number missing from the record, e.g. a confidence or an ASN.
+func numberOrUnknown(value int) string {
+	if value == 0 {
+		return Unknown
+	}
+	return strconv.Itoa(value)
+}
+
+// scoreOrUnknown returns Unknown for a score missing from the record.
+func scoreOrUnknown(value float64) string {
+	if value == 0 {
+		return Unknown
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
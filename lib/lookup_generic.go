@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
+)
+
+// LookupGeoIPGeneric looks up the record of a generic DB, decoded as a tree of values.
+type LookupGeoIPGeneric func(ip net.IP) (interface{}, error)
+
+// CreateGenericDBLookup CreateGenericDBLookup.
+func CreateGenericDBLookup(rdr *geoip2.GenericReader) LookupGeoIPGeneric {
+	return func(ip net.IP) (interface{}, error) {
+		record, err := rdr.Lookup(ip)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		return record, nil
+	}
+}
+
+// CreateGenericDBLookupIso88591 CreateGenericDBLookup.
+func CreateGenericDBLookupIso88591(rdr *geoip2_iso88591.GenericReader) LookupGeoIPGeneric {
+	return func(ip net.IP) (interface{}, error) {
+		record, err := rdr.Lookup(ip)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		return record, nil
+	}
+}
+
+// NewLookupGeneric Create a new Lookup with the default options, its DB is shared with the other middleware instances.
+func NewLookupGeneric(dbPath, name string, iso88591 bool) (LookupGeoIPGeneric, error) {
+	options := ConfigToOptions(&Config{Iso88591: iso88591})
+	lookup, _, err := newLookupGeneric(dbPath, name, &options)
+	return lookup, err
+}
+
+// newLookupGeneric creates a Lookup over the DB shared by the middleware instances, release drops its reference.
+func newLookupGeneric(dbPath, name string, options *Options) (LookupGeoIPGeneric, func(), error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil, fmt.Errorf("generic DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
+	}
+	var lookupGeneric LookupGeoIPGeneric
+	var release func()
+
+	if options.Iso88591 {
+		shared, releaseDB, err := openSharedDB("generic", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2_iso88591.NewGenericReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("generic lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2_iso88591.GenericReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupGeneric = CreateGenericDBLookupIso88591(rdr)
+	} else {
+		shared, releaseDB, err := openSharedDB("generic", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2.NewGenericReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("generic lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2.GenericReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupGeneric = CreateGenericDBLookup(rdr)
+	}
+	return lookupGeneric, release, nil
+}
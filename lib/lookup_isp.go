@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
+)
+
+// GeoIPIspResult in memory, the struct takes 120 bytes on 64-bit platforms, plus the text of its values.
+type GeoIPIspResult struct {
+	asn               GeoIPAsnResult
+	isp               string
+	organization      string
+	mobileCountryCode string
+	mobileNetworkCode string
+}
+
+// LookupGeoIPIsp LookupGeoIPIsp.
+type LookupGeoIPIsp func(ip net.IP) (*GeoIPIspResult, error)
+
+// CreateIspDBLookup CreateIspDBLookup.
+func CreateIspDBLookup(rdr *geoip2.ISPReader) LookupGeoIPIsp {
+	source := rdr.Metadata().DatabaseType
+	return func(ip net.IP) (*GeoIPIspResult, error) {
+		rec, err := rdr.Lookup(ip)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		returnVal := GeoIPIspResult{
+			asn: GeoIPAsnResult{
+				number:       strconv.Itoa(int(rec.AutonomousSystemNumber)),
+				organization: rec.AutonomousSystemOrganization,
+				source:       source,
+			},
+			isp:               valueOrUnknown(rec.ISP),
+			organization:      valueOrUnknown(rec.Organization),
+			mobileCountryCode: valueOrUnknown(rec.MobileCountryCode),
+			mobileNetworkCode: valueOrUnknown(rec.MobileNetworkCode),
+		}
+		return &returnVal, nil
+	}
+}
+
+// CreateIspDBLookupIso88591 CreateIspDBLookup.
+func CreateIspDBLookupIso88591(rdr *geoip2_iso88591.ISPReader) LookupGeoIPIsp {
+	source := rdr.Metadata().DatabaseType
+	return func(ip net.IP) (*GeoIPIspResult, error) {
+		rec, err := rdr.Lookup(ip)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		returnVal := GeoIPIspResult{
+			asn: GeoIPAsnResult{
+				number:       strconv.Itoa(int(rec.AutonomousSystemNumber)),
+				organization: rec.AutonomousSystemOrganization,
+				source:       source,
+			},
+			isp:               valueOrUnknown(rec.ISP),
+			organization:      valueOrUnknown(rec.Organization),
+			mobileCountryCode: valueOrUnknown(rec.MobileCountryCode),
+			mobileNetworkCode: valueOrUnknown(rec.MobileNetworkCode),
+		}
+		return &returnVal, nil
+	}
+}
+
+// CreateIspAsnLookup uses the ISP lookup as the source of the ASN data, the ISP fields come with the ASN so the ISP DB
+// is searched once. An IP the ISP DB doesn't know has an unknown ASN, so the next DBs of the chain are looked up.
+func CreateIspAsnLookup(lookupIsp LookupGeoIPIsp) LookupGeoIPAsn {
+	return func(ip net.IP) (*GeoIPAsnResult, error) {
+		res, err := lookupIsp(ip)
+		if err != nil {
+			return &GeoIPAsnResult{number: Unknown, organization: Unknown, isp: newUnknownIspResult()}, nil
+		}
+		asn := res.asn
+		asn.isp = res
+		return &asn, nil
+	}
+}
+
+// newUnknownIspResult the result of an IP the ISP DB doesn't know.
+func newUnknownIspResult() *GeoIPIspResult {
+	return &GeoIPIspResult{
+		asn:               GeoIPAsnResult{number: Unknown, organization: Unknown},
+		isp:               Unknown,
+		organization:      Unknown,
+		mobileCountryCode: Unknown,
+		mobileNetworkCode: Unknown,
+	}
+}
+
+// NewLookupIsp Create a new Lookup with the default options, its DB is shared with the other middleware instances.
+func NewLookupIsp(dbPath, name string, iso88591 bool) (LookupGeoIPIsp, error) {
+	options := ConfigToOptions(&Config{Iso88591: iso88591})
+	lookup, _, err := newLookupIsp(dbPath, name, &options)
+	return lookup, err
+}
+
+// newLookupIsp creates a Lookup over the DB shared by the middleware instances, release drops its reference.
+func newLookupIsp(dbPath, name string, options *Options) (LookupGeoIPIsp, func(), error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil, fmt.Errorf("isp DB not found: db=%s, name=%s, err=%w", dbPath, name, err)
+	}
+	var lookupIsp LookupGeoIPIsp
+	var release func()
+
+	if options.Iso88591 {
+		shared, releaseDB, err := openSharedDB("isp", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2_iso88591.NewISPReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("isp lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2_iso88591.ISPReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupIsp = CreateIspDBLookupIso88591(rdr)
+	} else {
+		shared, releaseDB, err := openSharedDB("isp", dbPath, options, func(buffer []byte) (interface{}, error) {
+			return geoip2.NewISPReader(buffer)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("isp lookup DB is not initialized: db=%s, name=%s, err=%w", dbPath, name, err)
+		}
+		rdr := shared.(*geoip2.ISPReader)
+		release = releaseDB
+		options.loadedDB(rdr.Metadata().BuildEpoch, dbPath, name)
+		lookupIsp = CreateIspDBLookup(rdr)
+	}
+	return lookupIsp, release, nil
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+)
+
+// errMmapUnsupported the platform can't map the DB files.
+var errMmapUnsupported = errors.New("mmap is not supported")
+
+// openDB creates the reader of dbPath with newReader, over a read-only mapping of the file when mmap is set and
+// the platform supports it, over a copy on the heap otherwise. Compressed DBs are always decompressed on the heap.
+func openDB(dbPath string, mmap bool, newReader func(buffer []byte) (interface{}, error)) (interface{}, error) {
+	if mmap {
+		buffer, err := mmapFile(dbPath)
+		if err == nil && isCompressedDB(buffer) {
+			munmapFile(buffer)
+			err = errors.New("compressed DB")
+		}
+		if err == nil {
+			reader, err := newReader(buffer)
+			if err != nil {
+				munmapFile(buffer)
+				return nil, err
+			}
+			// the reader holds the only reference to the mapping, it is unmapped once the reader is collected
+			runtime.SetFinalizer(reader, func(interface{}) { munmapFile(buffer) })
+			return reader, nil
+		}
+		log.Printf("[geoip2] DB can't be mapped, it is loaded on the heap: db=%s, err=%v", dbPath, err)
+	}
+	buffer, err := os.ReadFile(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return openCompressedDB(buffer, newReader)
+}
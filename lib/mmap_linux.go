@@ -0,0 +1,37 @@
+//go:build linux && gc
+// +build linux,gc
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps dbPath read-only, the pages are shared with every process reading the file.
+func mmapFile(dbPath string) ([]byte, error) {
+	file, err := os.Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	size := int(info.Size())
+	if size <= 0 || int64(size) != info.Size() {
+		return nil, errors.New("invalid DB size")
+	}
+	buffer, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return buffer, nil
+}
+
+func munmapFile(buffer []byte) {
+	_ = syscall.Munmap(buffer)
+}
@@ -0,0 +1,11 @@
+//go:build !linux || !gc
+// +build !linux !gc
+
+package lib
+
+// mmapFile is only supported on Linux, yaegi can't map files either.
+func mmapFile(_ string) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmapFile(_ []byte) {}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"net"
+)
+
+const (
+	// NetworkTypePublic globally routable address, looked up in the GeoIP DBs.
+	NetworkTypePublic = "public"
+	// NetworkTypePrivate RFC 1918, unique local and link-local address.
+	NetworkTypePrivate = "private"
+	// NetworkTypeLoopback loopback address.
+	NetworkTypeLoopback = "loopback"
+	// NetworkTypeCGNAT carrier-grade NAT shared address (RFC 6598).
+	NetworkTypeCGNAT = "cgnat"
+	// NetworkTypeReserved documentation, benchmarking, multicast and other special-purpose address.
+	NetworkTypeReserved = "reserved"
+)
+
+// networkTypes special-purpose ranges, checked in order.
+//
+//nolint:gochecknoglobals
+var networkTypes = []struct {
+	networkType string
+	networks    []*net.IPNet
+}{
+	{NetworkTypeLoopback, mustParseNetworks("127.0.0.0/8", "::1/128")},
+	{NetworkTypePrivate, mustParseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+		"169.254.0.0/16", "fe80::/10")},
+	{NetworkTypeCGNAT, mustParseNetworks("100.64.0.0/10")},
+	{NetworkTypeReserved, mustParseNetworks("0.0.0.0/8", "192.0.0.0/24", "192.0.2.0/24", "198.18.0.0/15",
+		"198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4", "::/128", "100::/64",
+		"2001:db8::/32", "ff00::/8")},
+}
+
+// classifyIP returns the network type of ip, invalid IPs are public so their lookup fails as before.
+func classifyIP(ip net.IP) string {
+	if ip == nil {
+		return NetworkTypePublic
+	}
+	for _, ranges := range networkTypes {
+		for _, network := range ranges.networks {
+			if network.Contains(ip) {
+				return ranges.networkType
+			}
+		}
+	}
+	return NetworkTypePublic
+}
+
+func isNetworkType(value string) bool {
+	switch value {
+	case NetworkTypePublic, NetworkTypePrivate, NetworkTypeLoopback, NetworkTypeCGNAT, NetworkTypeReserved:
+		return true
+	default:
+		return false
+	}
+}
+
+func mustParseNetworks(values ...string) []*net.IPNet {
+	networks, err := parseNetworks(values)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
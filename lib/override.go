@@ -0,0 +1,262 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+)
+
+// overrideSource source of the data of an override, sent in place of a DB type.
+const overrideSource = "override"
+
+// Override fixed GeoIP data of a network, used instead of the DB lookups.
+type Override struct {
+	Network         string            `json:"network"`
+	Country         string            `json:"country,omitempty"`
+	CountryCode     string            `json:"countryCode,omitempty"`
+	Region          string            `json:"region,omitempty"`
+	RegionCode      string            `json:"regionCode,omitempty"`
+	City            string            `json:"city,omitempty"`
+	Asn             string            `json:"asn,omitempty"`
+	AsnOrganization string            `json:"asnOrganization,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+func (override *Override) hasLocation() bool {
+	return override.Country != "" || override.CountryCode != "" || override.Region != "" ||
+		override.RegionCode != "" || override.City != ""
+}
+
+func (override *Override) hasAsn() bool {
+	return override.Asn != "" || override.AsnOrganization != ""
+}
+
+type overrideEntry struct {
+	network  *net.IPNet
+	override Override
+}
+
+// OverrideTable networks with fixed GeoIP data, matched by longest prefix.
+type OverrideTable struct {
+	entries []overrideEntry
+}
+
+// LoadOverrides builds the override table of the inline overrides followed by the ones of the overrides file,
+// nil when there are none.
+func LoadOverrides(config *Config) (*OverrideTable, error) {
+	overrides := append([]Override{}, config.Overrides...)
+	if config.OverridesFile != "" {
+		data, err := os.ReadFile(config.OverridesFile)
+		if err != nil {
+			return nil, fmt.Errorf("overrides file not found: file=%s, err=%w", config.OverridesFile, err)
+		}
+		fileOverrides, err := parseOverrides(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid overrides file: file=%s, err=%w", config.OverridesFile, err)
+		}
+		overrides = append(overrides, fileOverrides...)
+	}
+	if len(overrides) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+	return NewOverrideTable(overrides)
+}
+
+// NewOverrideTable creates the override table, the first override of a repeated network wins.
+func NewOverrideTable(overrides []Override) (*OverrideTable, error) {
+	table := &OverrideTable{}
+	for _, override := range overrides {
+		networks, err := parseNetworks([]string{override.Network})
+		if err != nil || len(networks) == 0 {
+			return nil, fmt.Errorf("invalid override network: %q", override.Network)
+		}
+		override.CountryCode = strings.ToUpper(strings.TrimSpace(override.CountryCode))
+		override.Asn = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(override.Asn)), "AS")
+		table.entries = append(table.entries, overrideEntry{network: networks[0], override: override})
+	}
+	// the most specific networks first, so the first match is the longest prefix
+	sort.SliceStable(table.entries, func(i, j int) bool {
+		iOnes, _ := table.entries[i].network.Mask.Size()
+		jOnes, _ := table.entries[j].network.Mask.Size()
+		return iOnes > jOnes
+	})
+	return table, nil
+}
+
+// HasLocation reports whether any override has location data.
+func (table *OverrideTable) HasLocation() bool {
+	for i := range table.entries {
+		if table.entries[i].override.hasLocation() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAsn reports whether any override has ASN data.
+func (table *OverrideTable) HasAsn() bool {
+	for i := range table.entries {
+		if table.entries[i].override.hasAsn() {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the override of the longest network holding ip, nil when none does.
+func (table *OverrideTable) Match(ip net.IP) *Override {
+	if table == nil || ip == nil {
+		return nil
+	}
+	for i := range table.entries {
+		if table.entries[i].network.Contains(ip) {
+			return &table.entries[i].override
+		}
+	}
+	return nil
+}
+
+// CreateOverrideCityLookup looks up the overrides with location data before lookup, which may be nil.
+func CreateOverrideCityLookup(table *OverrideTable, lookup LookupGeoIPCity) LookupGeoIPCity {
+	return func(ip net.IP) (*GeoIPCityResult, error) {
+		if override := table.Match(ip); override != nil && override.hasLocation() {
+			return newOverrideCityResult(override), nil
+		}
+		if lookup == nil {
+			return nil, geoip2.ErrNotFound
+		}
+		return lookup(ip)
+	}
+}
+
+// CreateOverrideCountryLookup looks up the overrides with location data before lookup.
+func CreateOverrideCountryLookup(table *OverrideTable, lookup LookupGeoIPCountry) LookupGeoIPCountry {
+	return func(ip net.IP) (*GeoIPCountryResult, error) {
+		if override := table.Match(ip); override != nil && override.hasLocation() {
+			return &newOverrideCityResult(override).GeoIPCountryResult, nil
+		}
+		return lookup(ip)
+	}
+}
+
+// CreateOverrideAsnLookup looks up the overrides with ASN data before lookup, which may be nil.
+func CreateOverrideAsnLookup(table *OverrideTable, lookup LookupGeoIPAsn) LookupGeoIPAsn {
+	return func(ip net.IP) (*GeoIPAsnResult, error) {
+		if override := table.Match(ip); override != nil && override.hasAsn() {
+			result := &GeoIPAsnResult{
+				number:       valueOrUnknown(override.Asn),
+				organization: valueOrUnknown(override.AsnOrganization),
+				source:       overrideSource,
+			}
+			if lookup != nil {
+				// the ISP fields still come from the ISP DB
+				if res, err := lookup(ip); err == nil {
+					result.isp = res.isp
+				}
+			}
+			return result, nil
+		}
+		if lookup == nil {
+			return nil, geoip2.ErrNotFound
+		}
+		return lookup(ip)
+	}
+}
+
+func newOverrideCityResult(override *Override) *GeoIPCityResult {
+	return &GeoIPCityResult{
+		GeoIPCountryResult: GeoIPCountryResult{
+			country:                   valueOrUnknown(override.Country),
+			countryCode:               valueOrUnknown(override.CountryCode),
+			continent:                 Unknown,
+			continentCode:             Unknown,
+			isInEuropeanUnion:         Unknown,
+			registeredCountry:         Unknown,
+			registeredCountryCode:     Unknown,
+			representedCountry:        Unknown,
+			representedCountryCode:    Unknown,
+			registeredCountryMismatch: Unknown,
+			source:                    overrideSource,
+		},
+		region:           valueOrUnknown(override.Region),
+		regionCode:       valueOrUnknown(override.RegionCode),
+		subdivisions:     valueOrUnknown(override.Region),
+		subdivisionCodes: valueOrUnknown(override.RegionCode),
+		city:             valueOrUnknown(override.City),
+		postalCode:       Unknown,
+		latitude:         Unknown,
+		longitude:        Unknown,
+		accuracyRadius:   Unknown,
+		geohash:          Unknown,
+		timeZone:         Unknown,
+		metroCode:        Unknown,
+	}
+}
+
+// setOverrideLabels sets the custom label headers of the override of ip.
+func setOverrideLabels(req *http.Request, table *OverrideTable, ip net.IP) {
+	if override := table.Match(ip); override != nil {
+		for header, value := range override.Labels {
+			req.Header.Set(header, value)
+		}
+	}
+}
+
+// parseOverrides parses a JSON or YAML list of overrides.
+func parseOverrides(data []byte) ([]Override, error) {
+	var items []map[string]interface{}
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		if bytes.HasPrefix(trimmed, []byte("{")) {
+			var document struct {
+				Overrides []map[string]interface{} `json:"overrides"`
+			}
+			if err := decoder.Decode(&document); err != nil {
+				return nil, fmt.Errorf("%w", err)
+			}
+			items = document.Overrides
+		} else if err := decoder.Decode(&items); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	} else {
+		var err error
+		if items, err = parseOverridesYAML(string(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	overrides := make([]Override, 0, len(items))
+	for _, item := range items {
+		normalized := make(map[string]interface{}, len(item))
+		for key, value := range item {
+			if key == "labels" {
+				normalized[key] = value
+			} else {
+				normalized[key] = fmt.Sprint(value)
+			}
+		}
+		encoded, err := json.Marshal(normalized)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		var override Override
+		if err := json.Unmarshal(encoded, &override); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		if override.Network == "" {
+			return nil, errors.New("override without network")
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, nil
+}
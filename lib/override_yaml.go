@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseOverridesYAML parses the YAML subset of an overrides file: a list, optionally under an overrides key,
+// of maps with scalar values and a labels map, e.g.
+//
+//	overrides:
+//	  - network: 10.0.0.0/8
+//	    countryCode: BR
+//	    labels:
+//	      X-Office: sao-paulo
+func parseOverridesYAML(data string) ([]map[string]interface{}, error) {
+	var items []map[string]interface{}
+	var item map[string]interface{}
+	var labels map[string]string
+	itemIndent, labelsIndent := -1, -1
+
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+		indent := len(line) - len(content)
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in YAML indentation", number+1)
+		}
+		if item == nil && indent == 0 && content == "overrides:" {
+			continue
+		}
+
+		if strings.HasPrefix(content, "- ") || content == "-" {
+			item = map[string]interface{}{}
+			items = append(items, item)
+			labels, labelsIndent = nil, -1
+			itemIndent = indent + 2
+			content = strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+			if content == "" {
+				continue
+			}
+			indent = itemIndent
+		}
+		if item == nil {
+			return nil, fmt.Errorf("line %d: expected a list item", number+1)
+		}
+
+		key, value, found := strings.Cut(content, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key: value", number+1)
+		}
+		key, value = strings.TrimSpace(key), unquoteYAML(strings.TrimSpace(value))
+		switch {
+		case labels != nil && indent > itemIndent:
+			if labelsIndent == -1 {
+				labelsIndent = indent
+			} else if indent != labelsIndent {
+				return nil, fmt.Errorf("line %d: invalid indentation", number+1)
+			}
+			labels[key] = value
+		case indent == itemIndent && key == "labels" && value == "":
+			labels = map[string]string{}
+			labelsIndent = -1
+			item[key] = labels
+		case indent == itemIndent:
+			labels = nil
+			item[key] = value
+		default:
+			return nil, fmt.Errorf("line %d: invalid indentation", number+1)
+		}
+	}
+	return items, nil
+}
+
+// stripYAMLComment removes a # comment that is not inside a quoted value.
+func stripYAMLComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0:
+			if line[i] == quote {
+				quote = 0
+			}
+		case line[i] == '"' || line[i] == '\'':
+			quote = line[i]
+		case line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteYAML(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,285 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	geoip2 "github.com/thiagotognoli/traefikgeoip/geoip2"
+	geoip2_iso88591 "github.com/thiagotognoli/traefikgeoip/geoip2_iso88591"
+)
+
+// probeIP is looked up in a reloaded DB to check it can be read before it is used.
+//
+//nolint:gochecknoglobals
+var probeIP = net.ParseIP("1.1.1.1")
+
+// dbWatcher polls the modification time and size of a DB file, and swaps the lookup when they change.
+// The current lookup is kept when the new file can't be loaded, e.g. a corrupt or half written file.
+type dbWatcher struct {
+	dbPath  string
+	name    string
+	load    func() (interface{}, func(), error)
+	lookup  atomic.Value
+	release func()
+	modTime time.Time
+	size    int64
+}
+
+// watchDB loads the DB and reloads it in the background every interval until ctx is done.
+// The shared DB is released when ctx is done.
+func watchDB(ctx context.Context, dbPath, name string, interval time.Duration, load func() (interface{}, func(), error)) (*dbWatcher, error) {
+	watcher := &dbWatcher{dbPath: dbPath, name: name, load: load}
+	if info, err := os.Stat(dbPath); err == nil {
+		watcher.modTime, watcher.size = info.ModTime(), info.Size()
+	}
+	lookup, release, err := load()
+	if err != nil {
+		return nil, err
+	}
+	watcher.lookup.Store(lookup)
+	watcher.release = release
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				watcher.release()
+				return
+			case <-ticker.C:
+				watcher.reloadIfChanged()
+			}
+		}
+	}()
+	return watcher, nil
+}
+
+// reloadIfChanged reports whether a new DB was swapped in.
+func (watcher *dbWatcher) reloadIfChanged() bool {
+	info, err := os.Stat(watcher.dbPath)
+	if err != nil || (info.ModTime().Equal(watcher.modTime) && info.Size() == watcher.size) {
+		return false
+	}
+	// a failed load is only retried when the file changes again
+	watcher.modTime, watcher.size = info.ModTime(), info.Size()
+
+	lookup, release, err := watcher.safeLoad()
+	if err != nil {
+		log.Printf("[geoip2] DB reload failed, keeping the last loaded DB: db=%s, name=%s, err=%v", watcher.dbPath, watcher.name, err)
+		return false
+	}
+	watcher.lookup.Store(lookup)
+	// the in-flight lookups keep the previous reader until they are done
+	watcher.release()
+	watcher.release = release
+	log.Printf("[geoip2] DB reloaded: db=%s, name=%s", watcher.dbPath, watcher.name)
+	return true
+}
+
+// safeLoad loads the DB, a corrupt DB may panic while it is decoded.
+func (watcher *dbWatcher) safeLoad() (lookup interface{}, release func(), err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			lookup, release, err = nil, nil, fmt.Errorf("corrupt DB: %v", r)
+		}
+	}()
+	return watcher.load()
+}
+
+// probe looks up probeIP in a loaded DB, the DB is released when the lookup fails or panics.
+func probe(release func(), lookup func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("corrupt DB: %v", r)
+		}
+		if err != nil {
+			release()
+		}
+	}()
+	return probeError(lookup())
+}
+
+// probeError returns the error of the probe lookup, a DB that doesn't hold the probe IP is fine.
+func probeError(err error) error {
+	if err == nil || errors.Is(err, geoip2.ErrNotFound) || errors.Is(err, geoip2_iso88591.ErrNotFound) {
+		return nil
+	}
+	return fmt.Errorf("DB lookup failed: %w", err)
+}
+
+// newReloading loads a lookup and, when interval is positive, reloads it when the DB file changes. The loaded
+// lookup is checked by looking up probeIP, it is shared with the other instances until ctx is done.
+func newReloading(ctx context.Context, dbPath, name string, interval time.Duration,
+	load func() (interface{}, func(), error), probeLookup func(interface{}) error,
+) (*dbWatcher, error) {
+	if interval <= 0 {
+		lookup, release, err := load()
+		if err != nil {
+			return nil, err
+		}
+		releaseOnDone(ctx, release)
+		watcher := &dbWatcher{dbPath: dbPath, name: name, load: load, release: release}
+		watcher.lookup.Store(lookup)
+		return watcher, nil
+	}
+	return watchDB(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := load()
+		if err != nil {
+			return nil, nil, err
+		}
+		return lookup, release, probe(release, func() error {
+			return probeLookup(lookup)
+		})
+	})
+}
+
+// NewReloadingLookupCity creates a City lookup reloaded when the DB file changes, checked every interval.
+// The DB is only loaded once when interval is 0, it is shared with the other instances until ctx is done.
+func NewReloadingLookupCity(ctx context.Context, dbPath, name string, options *Options, interval time.Duration) (LookupGeoIPCity, error) {
+	watcher, err := newReloading(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := newLookupCity(dbPath, name, options)
+		return lookup, release, err
+	}, func(lookup interface{}) error {
+		_, err := lookup.(LookupGeoIPCity)(probeIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) (*GeoIPCityResult, error) {
+		return watcher.lookup.Load().(LookupGeoIPCity)(ip)
+	}, nil
+}
+
+// NewReloadingLookupCountry creates a Country lookup reloaded when the DB file changes, checked every interval.
+// The DB is only loaded once when interval is 0, it is shared with the other instances until ctx is done.
+func NewReloadingLookupCountry(ctx context.Context, dbPath, name string, options *Options, interval time.Duration) (LookupGeoIPCountry, error) {
+	watcher, err := newReloading(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := newLookupCountry(dbPath, name, options)
+		return lookup, release, err
+	}, func(lookup interface{}) error {
+		_, err := lookup.(LookupGeoIPCountry)(probeIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) (*GeoIPCountryResult, error) {
+		return watcher.lookup.Load().(LookupGeoIPCountry)(ip)
+	}, nil
+}
+
+// NewReloadingLookupAsn creates an ASN lookup reloaded when the DB file changes, checked every interval.
+// The DB is only loaded once when interval is 0, it is shared with the other instances until ctx is done.
+func NewReloadingLookupAsn(ctx context.Context, dbPath, name string, options *Options, interval time.Duration) (LookupGeoIPAsn, error) {
+	watcher, err := newReloading(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := newLookupAsn(dbPath, name, options)
+		return lookup, release, err
+	}, func(lookup interface{}) error {
+		_, err := lookup.(LookupGeoIPAsn)(probeIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) (*GeoIPAsnResult, error) {
+		return watcher.lookup.Load().(LookupGeoIPAsn)(ip)
+	}, nil
+}
+
+// NewReloadingLookupIsp creates an ISP lookup reloaded when the DB file changes, checked every interval.
+// The DB is only loaded once when interval is 0, it is shared with the other instances until ctx is done.
+func NewReloadingLookupIsp(ctx context.Context, dbPath, name string, options *Options, interval time.Duration) (LookupGeoIPIsp, error) {
+	watcher, err := newReloading(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := newLookupIsp(dbPath, name, options)
+		return lookup, release, err
+	}, func(lookup interface{}) error {
+		_, err := lookup.(LookupGeoIPIsp)(probeIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) (*GeoIPIspResult, error) {
+		return watcher.lookup.Load().(LookupGeoIPIsp)(ip)
+	}, nil
+}
+
+// NewReloadingLookupAnonymous creates an Anonymous-IP lookup reloaded when the DB file changes, checked every interval.
+// The DB is only loaded once when interval is 0, it is shared with the other instances until ctx is done.
+func NewReloadingLookupAnonymous(ctx context.Context, dbPath, name string, options *Options, interval time.Duration) (LookupGeoIPAnonymous, error) {
+	watcher, err := newReloading(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := newLookupAnonymous(dbPath, name, options)
+		return lookup, release, err
+	}, func(lookup interface{}) error {
+		_, err := lookup.(LookupGeoIPAnonymous)(probeIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) (*GeoIPAnonymousResult, error) {
+		return watcher.lookup.Load().(LookupGeoIPAnonymous)(ip)
+	}, nil
+}
+
+// NewReloadingLookupConnectionType creates a Connection-Type lookup reloaded when the DB file changes, checked every interval.
+// The DB is only loaded once when interval is 0, it is shared with the other instances until ctx is done.
+func NewReloadingLookupConnectionType(ctx context.Context, dbPath, name string, options *Options, interval time.Duration) (LookupGeoIPConnectionType, error) {
+	watcher, err := newReloading(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := newLookupConnectionType(dbPath, name, options)
+		return lookup, release, err
+	}, func(lookup interface{}) error {
+		_, err := lookup.(LookupGeoIPConnectionType)(probeIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) (string, error) {
+		return watcher.lookup.Load().(LookupGeoIPConnectionType)(ip)
+	}, nil
+}
+
+// NewReloadingLookupDomain creates a Domain lookup reloaded when the DB file changes, checked every interval.
+// The DB is only loaded once when interval is 0, it is shared with the other instances until ctx is done.
+func NewReloadingLookupDomain(ctx context.Context, dbPath, name string, options *Options, interval time.Duration) (LookupGeoIPDomain, error) {
+	watcher, err := newReloading(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := newLookupDomain(dbPath, name, options)
+		return lookup, release, err
+	}, func(lookup interface{}) error {
+		_, err := lookup.(LookupGeoIPDomain)(probeIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) (string, error) {
+		return watcher.lookup.Load().(LookupGeoIPDomain)(ip)
+	}, nil
+}
+
+// NewReloadingLookupGeneric creates a generic lookup reloaded when the DB file changes, checked every interval.
+// The DB is only loaded once when interval is 0, it is shared with the other instances until ctx is done.
+func NewReloadingLookupGeneric(ctx context.Context, dbPath, name string, options *Options, interval time.Duration) (LookupGeoIPGeneric, error) {
+	watcher, err := newReloading(ctx, dbPath, name, interval, func() (interface{}, func(), error) {
+		lookup, release, err := newLookupGeneric(dbPath, name, options)
+		return lookup, release, err
+	}, func(lookup interface{}) error {
+		_, err := lookup.(LookupGeoIPGeneric)(probeIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) (interface{}, error) {
+		return watcher.lookup.Load().(LookupGeoIPGeneric)(ip)
+	}, nil
+}
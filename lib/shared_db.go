@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// sharedDB a reader shared by the middleware instances, with the file state it was loaded from.
+type sharedDB struct {
+	reader  interface{}
+	modTime time.Time
+	size    int64
+	refs    int
+}
+
+// sharedDBs readers loaded by every middleware instance of the process, keyed by reader kind, encoding, mmap and path.
+//
+//nolint:gochecknoglobals
+var sharedDBs = struct {
+	sync.Mutex
+	dbs map[string]*sharedDB
+}{dbs: map[string]*sharedDB{}}
+
+// openSharedDB returns the reader of dbPath, created by newReader only when no other instance holds it or when the file
+// changed since it was loaded. release drops the reference, the reader is forgotten once no instance holds it.
+func openSharedDB(kind, dbPath string, options *Options, newReader func(buffer []byte) (interface{}, error)) (interface{}, func(), error) {
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+	key := fmt.Sprintf("%s:%t:%t:%s", kind, options.Iso88591, options.Mmap, dbPath)
+
+	sharedDBs.Lock()
+	defer sharedDBs.Unlock()
+	db, ok := sharedDBs.dbs[key]
+	if !ok || !db.modTime.Equal(info.ModTime()) || db.size != info.Size() {
+		reader, err := openDB(dbPath, options.Mmap, newReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the instances still holding the previous reader keep it until they reload or stop
+		db = &sharedDB{reader: reader, modTime: info.ModTime(), size: info.Size()}
+		sharedDBs.dbs[key] = db
+	}
+	db.refs++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			sharedDBs.Lock()
+			defer sharedDBs.Unlock()
+			db.refs--
+			if db.refs == 0 && sharedDBs.dbs[key] == db {
+				delete(sharedDBs.dbs, key)
+			}
+		})
+	}
+	return db.reader, release, nil
+}
+
+// releaseOnDone calls release when ctx is done, ctx without cancellation holds the DB until the process exits.
+func releaseOnDone(ctx context.Context, release func()) {
+	if ctx.Done() == nil {
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		release()
+	}()
+}
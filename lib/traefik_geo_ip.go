@@ -13,6 +13,9 @@ type TraefikGeoIP struct {
 
 func (mw *TraefikGeoIP) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
 	ipStr := getClientIP(req, mw.Options)
-	req.Header.Set(IPAddressHeader, ipStr)
+	mw.Options.setHeader(req, FieldIPAddress, ipStr)
+	if denyCountry(reqWr, &mw.Options, ipStr, Unknown) {
+		return
+	}
 	mw.Next.ServeHTTP(reqWr, req)
 }
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// TraefikGeoIPAnonymous is a middleware that looks up the anonymity flags of the client IP address
+// from the Anonymous-IP database, it wraps one of the location middlewares.
+type TraefikGeoIPAnonymous struct {
+	Next            http.Handler
+	Name            string
+	Options         Options
+	LookupAnonymous LookupGeoIPAnonymous
+}
+
+func (mw *TraefikGeoIPAnonymous) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
+	ipStr := getClientIP(req, mw.Options)
+	res, err := mw.LookupAnonymous(net.ParseIP(ipStr))
+	if err != nil {
+		if mw.Options.Debug {
+			log.Printf("[geoip2] Unable to find Anonymous IP: ip=%s, err=%v", ipStr, err)
+		}
+		mw.Options.setHeader(req, FieldIsAnonymous, Unknown)
+		mw.Options.setHeader(req, FieldIsAnonymousVPN, Unknown)
+		mw.Options.setHeader(req, FieldIsHostingProvider, Unknown)
+		mw.Options.setHeader(req, FieldIsPublicProxy, Unknown)
+		mw.Options.setHeader(req, FieldIsTorExitNode, Unknown)
+		mw.Options.setHeader(req, FieldIsResidentialProxy, Unknown)
+	} else {
+		mw.Options.setHeader(req, FieldIsAnonymous, strconv.FormatBool(res.isAnonymous))
+		mw.Options.setHeader(req, FieldIsAnonymousVPN, strconv.FormatBool(res.isAnonymousVPN))
+		mw.Options.setHeader(req, FieldIsHostingProvider, strconv.FormatBool(res.isHostingProvider))
+		mw.Options.setHeader(req, FieldIsPublicProxy, strconv.FormatBool(res.isPublicProxy))
+		mw.Options.setHeader(req, FieldIsTorExitNode, strconv.FormatBool(res.isTorExitNode))
+		mw.Options.setHeader(req, FieldIsResidentialProxy, strconv.FormatBool(res.isResidentialProxy))
+		if denyAnonymous(reqWr, &mw.Options, ipStr, res) {
+			return
+		}
+	}
+	mw.Next.ServeHTTP(reqWr, req)
+}
@@ -16,17 +16,28 @@ type TraefikGeoIPAsn struct {
 
 func (mw *TraefikGeoIPAsn) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
 	ipStr := getClientIP(req, mw.Options)
-	req.Header.Set(IPAddressHeader, ipStr)
+	mw.Options.setHeader(req, FieldIPAddress, ipStr)
+	if denyCountry(reqWr, &mw.Options, ipStr, Unknown) {
+		return
+	}
 	res, err := mw.LookupAsn(net.ParseIP(ipStr))
+	asnNumber, asnOrganization := Unknown, Unknown
 	if err != nil {
 		if mw.Options.Debug {
 			log.Printf("[geoip2] Unable to find ASN: ip=%s, err=%v", ipStr, err)
 		}
-		req.Header.Set(ASNSystemNumberHeader, Unknown)
-		req.Header.Set(ASNOrganizationHeader, Unknown)
+		mw.Options.setHeader(req, FieldASN, Unknown)
+		mw.Options.setHeader(req, FieldASNOrganization, Unknown)
+		mw.Options.setHeader(req, FieldASNSource, Unknown)
 	} else {
-		req.Header.Set(ASNSystemNumberHeader, res.number)
-		req.Header.Set(ASNOrganizationHeader, res.organization)
+		asnNumber, asnOrganization = res.number, res.organization
+		mw.Options.setHeader(req, FieldASN, res.number)
+		mw.Options.setHeader(req, FieldASNOrganization, res.organization)
+		mw.Options.setHeader(req, FieldASNSource, valueOrUnknown(res.source))
+		setIspHeaders(req, &mw.Options, res.isp)
+	}
+	if denyAsn(reqWr, &mw.Options, ipStr, asnNumber, asnOrganization) {
+		return
 	}
 	mw.Next.ServeHTTP(reqWr, req)
 }
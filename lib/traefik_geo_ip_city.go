@@ -16,34 +16,62 @@ type TraefikGeoIPCity struct {
 
 func (mw *TraefikGeoIPCity) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
 	ipStr := getClientIP(req, mw.Options)
-	req.Header.Set(IPAddressHeader, ipStr)
+	mw.Options.setHeader(req, FieldIPAddress, ipStr)
 	res, err := mw.LookupCity(net.ParseIP(ipStr))
+	countryCode := Unknown
 	if err != nil {
 		if mw.Options.Debug {
 			log.Printf("[geoip2] Unable to find City: ip=%s, err=%v", ipStr, err)
 		}
-		req.Header.Set(CountryHeader, Unknown)
-		req.Header.Set(CountryCodeHeader, Unknown)
-		req.Header.Set(RegionHeader, Unknown)
-		req.Header.Set(RegionCodeHeader, Unknown)
-		req.Header.Set(CityHeader, Unknown)
-		req.Header.Set(LatitudeHeader, Unknown)
-		req.Header.Set(LongitudeHeader, Unknown)
-		req.Header.Set(AccuracyRadiusHeader, Unknown)
-		req.Header.Set(GeohashHeader, Unknown)
-		req.Header.Set(PostalCodeHeader, Unknown)
+		setCityUnknownHeaders(req, &mw.Options)
 	} else {
-		req.Header.Set(CountryHeader, res.country)
-		req.Header.Set(CountryCodeHeader, res.countryCode)
-		req.Header.Set(RegionHeader, res.region)
-		req.Header.Set(RegionCodeHeader, res.regionCode)
-		req.Header.Set(CityHeader, res.city)
-		req.Header.Set(LatitudeHeader, res.latitude)
-		req.Header.Set(LongitudeHeader, res.longitude)
-		req.Header.Set(AccuracyRadiusHeader, res.accuracyRadius)
-		req.Header.Set(GeohashHeader, res.geohash)
-		req.Header.Set(PostalCodeHeader, res.postalCode)
+		countryCode = res.countryCode
+		setCityHeaders(req, &mw.Options, res)
 	}
 
+	if denyCountry(reqWr, &mw.Options, ipStr, countryCode) {
+		return
+	}
 	mw.Next.ServeHTTP(reqWr, req)
 }
+
+// setCityHeaders sets the headers of a City DB result.
+func setCityHeaders(req *http.Request, options *Options, res *GeoIPCityResult) {
+	setCountryHeaders(req, options, &res.GeoIPCountryResult)
+	options.setHeader(req, FieldRegion, res.region)
+	options.setLocalizedHeaders(req, FieldRegion, res.regionNames)
+	options.setHeader(req, FieldRegionCode, res.regionCode)
+	options.setHeader(req, FieldSubdivisions, res.subdivisions)
+	options.setHeader(req, FieldSubdivisionCodes, res.subdivisionCodes)
+	options.setHeader(req, FieldCity, res.city)
+	options.setLocalizedHeaders(req, FieldCity, res.cityNames)
+	options.setHeader(req, FieldPostalCode, res.postalCode)
+	options.setHeader(req, FieldLatitude, res.latitude)
+	options.setHeader(req, FieldLongitude, res.longitude)
+	options.setHeader(req, FieldAccuracyRadius, res.accuracyRadius)
+	options.setHeader(req, FieldGeohash, res.geohash)
+	options.setHeader(req, FieldTimeZone, res.timeZone)
+	options.setHeader(req, FieldMetroCode, res.metroCode)
+	if res.enterprise != nil {
+		setEnterpriseHeaders(req, options, res.enterprise)
+	}
+}
+
+// setCityUnknownHeaders sets the headers of a City DB lookup that failed.
+func setCityUnknownHeaders(req *http.Request, options *Options) {
+	setCountryUnknownHeaders(req, options)
+	options.setHeader(req, FieldRegion, Unknown)
+	options.setLocalizedHeaders(req, FieldRegion, nil)
+	options.setHeader(req, FieldRegionCode, Unknown)
+	options.setHeader(req, FieldSubdivisions, Unknown)
+	options.setHeader(req, FieldSubdivisionCodes, Unknown)
+	options.setHeader(req, FieldCity, Unknown)
+	options.setLocalizedHeaders(req, FieldCity, nil)
+	options.setHeader(req, FieldPostalCode, Unknown)
+	options.setHeader(req, FieldLatitude, Unknown)
+	options.setHeader(req, FieldLongitude, Unknown)
+	options.setHeader(req, FieldAccuracyRadius, Unknown)
+	options.setHeader(req, FieldGeohash, Unknown)
+	options.setHeader(req, FieldTimeZone, Unknown)
+	options.setHeader(req, FieldMetroCode, Unknown)
+}
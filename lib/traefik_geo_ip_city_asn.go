@@ -17,45 +17,47 @@ type TraefikGeoIPCityAsn struct {
 
 func (mw *TraefikGeoIPCityAsn) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
 	ipStr := getClientIP(req, mw.Options)
-	req.Header.Set(IPAddressHeader, ipStr)
+	mw.Options.setHeader(req, FieldIPAddress, ipStr)
 	res, err := mw.LookupCity(net.ParseIP(ipStr))
+	countryCode := Unknown
 	if err != nil {
 		if mw.Options.Debug {
 			log.Printf("[geoip2] Unable to find City: ip=%s, err=%v", ipStr, err)
 		}
-		req.Header.Set(CountryHeader, Unknown)
-		req.Header.Set(CountryCodeHeader, Unknown)
-		req.Header.Set(RegionHeader, Unknown)
-		req.Header.Set(RegionCodeHeader, Unknown)
-		req.Header.Set(CityHeader, Unknown)
-		req.Header.Set(LatitudeHeader, Unknown)
-		req.Header.Set(LongitudeHeader, Unknown)
-		req.Header.Set(AccuracyRadiusHeader, Unknown)
-		req.Header.Set(GeohashHeader, Unknown)
-		req.Header.Set(PostalCodeHeader, Unknown)
+		setCityUnknownHeaders(req, &mw.Options)
 	} else {
-		req.Header.Set(CountryHeader, res.country)
-		req.Header.Set(CountryCodeHeader, res.countryCode)
-		req.Header.Set(RegionHeader, res.region)
-		req.Header.Set(RegionCodeHeader, res.regionCode)
-		req.Header.Set(CityHeader, res.city)
-		req.Header.Set(LatitudeHeader, res.latitude)
-		req.Header.Set(LongitudeHeader, res.longitude)
-		req.Header.Set(AccuracyRadiusHeader, res.accuracyRadius)
-		req.Header.Set(GeohashHeader, res.geohash)
-		req.Header.Set(PostalCodeHeader, res.postalCode)
+		countryCode = res.countryCode
+		setCityHeaders(req, &mw.Options, res)
+	}
+	if denyCountry(reqWr, &mw.Options, ipStr, countryCode) {
+		return
 	}
 	resAsn, err := mw.LookupAsn(net.ParseIP(ipStr))
+	if (err != nil || isUnknownOrZero(resAsn.number)) && res != nil && res.enterprise != nil && !isUnknownOrZero(res.enterprise.asn.number) {
+		// the Enterprise ASN is kept when the ASN DBs don't know the IP
+		resAsn, err = &res.enterprise.asn, nil
+	}
+	asnNumber, asnOrganization := Unknown, Unknown
 	if err != nil {
 		if mw.Options.Debug {
 			log.Printf("[geoip2] Unable to find ASN: ip=%s, err=%v", ipStr, err)
 		}
-		req.Header.Set(ASNSystemNumberHeader, Unknown)
-		req.Header.Set(ASNOrganizationHeader, Unknown)
+		mw.Options.setHeader(req, FieldASN, Unknown)
+		mw.Options.setHeader(req, FieldASNOrganization, Unknown)
+		mw.Options.setHeader(req, FieldASNSource, Unknown)
 	} else {
-		req.Header.Set(ASNSystemNumberHeader, resAsn.number)
-		req.Header.Set(ASNOrganizationHeader, resAsn.organization)
+		asnNumber, asnOrganization = resAsn.number, resAsn.organization
+		mw.Options.setHeader(req, FieldASN, resAsn.number)
+		mw.Options.setHeader(req, FieldASNOrganization, resAsn.organization)
+		mw.Options.setHeader(req, FieldASNSource, valueOrUnknown(resAsn.source))
+		if res == nil || res.enterprise == nil {
+			// the Enterprise DB fields win over the ISP DB ones
+			setIspHeaders(req, &mw.Options, resAsn.isp)
+		}
 	}
 
+	if denyAsn(reqWr, &mw.Options, ipStr, asnNumber, asnOrganization) {
+		return
+	}
 	mw.Next.ServeHTTP(reqWr, req)
 }
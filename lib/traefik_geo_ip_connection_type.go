@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"log"
+	"net"
+	"net/http"
+)
+
+// TraefikGeoIPConnectionType is a middleware that looks up the connection type of the client IP address
+// from the Connection-Type database, it wraps one of the location middlewares.
+type TraefikGeoIPConnectionType struct {
+	Next                 http.Handler
+	Name                 string
+	Options              Options
+	LookupConnectionType LookupGeoIPConnectionType
+}
+
+func (mw *TraefikGeoIPConnectionType) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
+	ipStr := getClientIP(req, mw.Options)
+	connectionType, err := mw.LookupConnectionType(net.ParseIP(ipStr))
+	if err != nil {
+		if mw.Options.Debug {
+			log.Printf("[geoip2] Unable to find Connection-Type: ip=%s, err=%v", ipStr, err)
+		}
+		mw.Options.setHeader(req, FieldConnectionType, Unknown)
+	} else {
+		mw.Options.setHeader(req, FieldConnectionType, connectionType)
+	}
+	mw.Next.ServeHTTP(reqWr, req)
+}
@@ -16,17 +16,52 @@ type TraefikGeoIPCountry struct {
 
 func (mw *TraefikGeoIPCountry) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
 	ipStr := getClientIP(req, mw.Options)
-	req.Header.Set(IPAddressHeader, ipStr)
+	mw.Options.setHeader(req, FieldIPAddress, ipStr)
 	res, err := mw.LookupCountry(net.ParseIP(ipStr))
+	countryCode := Unknown
 	if err != nil {
 		if mw.Options.Debug {
 			log.Printf("[geoip2] Unable to find Country: ip=%s, err=%v", ipStr, err)
 		}
-		req.Header.Set(CountryHeader, Unknown)
-		req.Header.Set(CountryCodeHeader, Unknown)
+		setCountryUnknownHeaders(req, &mw.Options)
 	} else {
-		req.Header.Set(CountryHeader, res.country)
-		req.Header.Set(CountryCodeHeader, res.countryCode)
+		countryCode = res.countryCode
+		setCountryHeaders(req, &mw.Options, res)
+	}
+	if denyCountry(reqWr, &mw.Options, ipStr, countryCode) {
+		return
 	}
 	mw.Next.ServeHTTP(reqWr, req)
 }
+
+// setCountryHeaders sets the headers of a Country DB result.
+func setCountryHeaders(req *http.Request, options *Options, res *GeoIPCountryResult) {
+	options.setHeader(req, FieldContinent, res.continent)
+	options.setHeader(req, FieldContinentCode, res.continentCode)
+	options.setHeader(req, FieldCountry, res.country)
+	options.setLocalizedHeaders(req, FieldCountry, res.countryNames)
+	options.setHeader(req, FieldCountryCode, res.countryCode)
+	options.setHeader(req, FieldIsInEuropeanUnion, res.isInEuropeanUnion)
+	options.setHeader(req, FieldRegisteredCountry, res.registeredCountry)
+	options.setHeader(req, FieldRegisteredCountryCode, res.registeredCountryCode)
+	options.setHeader(req, FieldRegisteredCountryMismatch, res.registeredCountryMismatch)
+	options.setHeader(req, FieldRepresentedCountry, res.representedCountry)
+	options.setHeader(req, FieldRepresentedCountryCode, res.representedCountryCode)
+	options.setHeader(req, FieldSource, valueOrUnknown(res.source))
+}
+
+// setCountryUnknownHeaders sets the headers of a Country DB lookup that failed.
+func setCountryUnknownHeaders(req *http.Request, options *Options) {
+	options.setHeader(req, FieldContinent, Unknown)
+	options.setHeader(req, FieldContinentCode, Unknown)
+	options.setHeader(req, FieldCountry, Unknown)
+	options.setLocalizedHeaders(req, FieldCountry, nil)
+	options.setHeader(req, FieldCountryCode, Unknown)
+	options.setHeader(req, FieldIsInEuropeanUnion, Unknown)
+	options.setHeader(req, FieldRegisteredCountry, Unknown)
+	options.setHeader(req, FieldRegisteredCountryCode, Unknown)
+	options.setHeader(req, FieldRegisteredCountryMismatch, Unknown)
+	options.setHeader(req, FieldRepresentedCountry, Unknown)
+	options.setHeader(req, FieldRepresentedCountryCode, Unknown)
+	options.setHeader(req, FieldSource, Unknown)
+}
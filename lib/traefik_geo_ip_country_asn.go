@@ -17,29 +17,40 @@ type TraefikGeoIPCountryAsn struct {
 
 func (mw *TraefikGeoIPCountryAsn) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
 	ipStr := getClientIP(req, mw.Options)
-	req.Header.Set(IPAddressHeader, ipStr)
+	mw.Options.setHeader(req, FieldIPAddress, ipStr)
 	res, err := mw.LookupCountry(net.ParseIP(ipStr))
+	countryCode := Unknown
 	if err != nil {
 		if mw.Options.Debug {
 			log.Printf("[geoip2] Unable to find Country: ip=%s, err=%v", ipStr, err)
 		}
-		req.Header.Set(CountryHeader, Unknown)
-		req.Header.Set(CountryCodeHeader, Unknown)
+		setCountryUnknownHeaders(req, &mw.Options)
 	} else {
-		req.Header.Set(CountryHeader, res.country)
-		req.Header.Set(CountryCodeHeader, res.countryCode)
+		countryCode = res.countryCode
+		setCountryHeaders(req, &mw.Options, res)
+	}
+	if denyCountry(reqWr, &mw.Options, ipStr, countryCode) {
+		return
 	}
 	resAsn, err := mw.LookupAsn(net.ParseIP(ipStr))
+	asnNumber, asnOrganization := Unknown, Unknown
 	if err != nil {
 		if mw.Options.Debug {
 			log.Printf("[geoip2] Unable to find ASN: ip=%s, err=%v", ipStr, err)
 		}
-		req.Header.Set(ASNSystemNumberHeader, Unknown)
-		req.Header.Set(ASNOrganizationHeader, Unknown)
+		mw.Options.setHeader(req, FieldASN, Unknown)
+		mw.Options.setHeader(req, FieldASNOrganization, Unknown)
+		mw.Options.setHeader(req, FieldASNSource, Unknown)
 	} else {
-		req.Header.Set(ASNSystemNumberHeader, resAsn.number)
-		req.Header.Set(ASNOrganizationHeader, resAsn.organization)
+		asnNumber, asnOrganization = resAsn.number, resAsn.organization
+		mw.Options.setHeader(req, FieldASN, resAsn.number)
+		mw.Options.setHeader(req, FieldASNOrganization, resAsn.organization)
+		mw.Options.setHeader(req, FieldASNSource, valueOrUnknown(resAsn.source))
+		setIspHeaders(req, &mw.Options, resAsn.isp)
 	}
 
+	if denyAsn(reqWr, &mw.Options, ipStr, asnNumber, asnOrganization) {
+		return
+	}
 	mw.Next.ServeHTTP(reqWr, req)
 }
@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"log"
+	"net"
+	"net/http"
+)
+
+// TraefikGeoIPDomain is a middleware that looks up the domain of the client IP address
+// from the Domain database, it wraps one of the location middlewares.
+type TraefikGeoIPDomain struct {
+	Next         http.Handler
+	Name         string
+	Options      Options
+	LookupDomain LookupGeoIPDomain
+}
+
+func (mw *TraefikGeoIPDomain) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
+	ipStr := getClientIP(req, mw.Options)
+	domain, err := mw.LookupDomain(net.ParseIP(ipStr))
+	if err != nil {
+		if mw.Options.Debug {
+			log.Printf("[geoip2] Unable to find Domain: ip=%s, err=%v", ipStr, err)
+		}
+		mw.Options.setHeader(req, FieldDomain, Unknown)
+	} else {
+		mw.Options.setHeader(req, FieldDomain, domain)
+	}
+	mw.Next.ServeHTTP(reqWr, req)
+}
@@ -0,0 +1,24 @@
+package lib
+
+import "net/http"
+
+// setEnterpriseHeaders sets the headers of the fields only filled by the GeoIP2 Enterprise DB.
+func setEnterpriseHeaders(req *http.Request, options *Options, res *GeoIPEnterpriseResult) {
+	options.setHeader(req, FieldCountryConfidence, res.countryConfidence)
+	options.setHeader(req, FieldRegionConfidence, res.regionConfidence)
+	options.setHeader(req, FieldCityConfidence, res.cityConfidence)
+	options.setHeader(req, FieldPostalConfidence, res.postalConfidence)
+	options.setHeader(req, FieldUserType, res.userType)
+	options.setHeader(req, FieldStaticIPScore, res.staticIPScore)
+	options.setHeader(req, FieldISP, res.isp)
+	options.setHeader(req, FieldOrganization, res.organization)
+	options.setHeader(req, FieldConnectionType, res.connectionType)
+	options.setHeader(req, FieldDomain, res.domain)
+	options.setHeader(req, FieldIsLegitimateProxy, res.isLegitimateProxy)
+	options.setHeader(req, FieldMobileCountryCode, res.mobileCountryCode)
+	options.setHeader(req, FieldMobileNetworkCode, res.mobileNetworkCode)
+	// replaced by the ASN DB when one is configured and knows the IP
+	options.setHeader(req, FieldASN, res.asn.number)
+	options.setHeader(req, FieldASNOrganization, res.asn.organization)
+	options.setHeader(req, FieldASNSource, valueOrUnknown(res.asn.source))
+}
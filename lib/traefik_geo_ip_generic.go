@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"log"
+	"net"
+	"net/http"
+)
+
+// TraefikGeoIPGeneric is a middleware that sends the values of the client IP address record in a generic DB
+// as headers, it wraps one of the location middlewares.
+type TraefikGeoIPGeneric struct {
+	Next          http.Handler
+	Name          string
+	Options       Options
+	LookupGeneric LookupGeoIPGeneric
+	Headers       []GenericHeader
+}
+
+func (mw *TraefikGeoIPGeneric) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
+	ipStr := getClientIP(req, mw.Options)
+	record, err := mw.LookupGeneric(net.ParseIP(ipStr))
+	if err != nil && mw.Options.Debug {
+		log.Printf("[geoip2] Unable to find generic record: ip=%s, err=%v", ipStr, err)
+	}
+	setGenericHeaders(req, mw.Headers, record)
+	mw.Next.ServeHTTP(reqWr, req)
+}
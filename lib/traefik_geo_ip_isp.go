@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"log"
+	"net"
+	"net/http"
+)
+
+// TraefikGeoIPIsp is a middleware that looks up the ISP of the client IP address
+// from the ISP database, it wraps one of the location middlewares. It is only used when the ASN is not wanted,
+// otherwise the ISP headers are set with the ASN ones.
+type TraefikGeoIPIsp struct {
+	Next      http.Handler
+	Name      string
+	Options   Options
+	LookupIsp LookupGeoIPIsp
+}
+
+func (mw *TraefikGeoIPIsp) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
+	ipStr := getClientIP(req, mw.Options)
+	res, err := mw.LookupIsp(net.ParseIP(ipStr))
+	if err != nil {
+		if mw.Options.Debug {
+			log.Printf("[geoip2] Unable to find ISP: ip=%s, err=%v", ipStr, err)
+		}
+		res = newUnknownIspResult()
+	}
+	setIspHeaders(req, &mw.Options, res)
+	mw.Next.ServeHTTP(reqWr, req)
+}
+
+// setIspHeaders sets the headers of an ISP DB result, nothing when the ISP DB was not looked up.
+func setIspHeaders(req *http.Request, options *Options, res *GeoIPIspResult) {
+	if res == nil {
+		return
+	}
+	options.setHeader(req, FieldISP, res.isp)
+	options.setHeader(req, FieldOrganization, res.organization)
+	options.setHeader(req, FieldMobileCountryCode, res.mobileCountryCode)
+	options.setHeader(req, FieldMobileNetworkCode, res.mobileNetworkCode)
+}
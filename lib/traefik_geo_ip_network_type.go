@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+)
+
+// TraefikGeoIPNetworkType is a middleware that classifies the client IP address, only public and overridden
+// addresses go through the Lookup middlewares, the others skip the GeoIP DBs and go straight to Next, unless the
+// access rules block unknown countries or ASNs and their network type is not allowed.
+type TraefikGeoIPNetworkType struct {
+	Next      http.Handler
+	Lookup    http.Handler
+	Name      string
+	Options   Options
+	Overrides *OverrideTable
+}
+
+func (mw *TraefikGeoIPNetworkType) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
+	ipStr := getClientIP(req, mw.Options)
+	ip := net.ParseIP(ipStr)
+	networkType := classifyIP(ip)
+	mw.Options.setHeader(req, FieldNetworkType, networkType)
+	if mw.Options.fields.has(FieldDatabaseBuildDate) {
+		mw.Options.setHeader(req, FieldDatabaseBuildDate, mw.Options.databaseBuildDate())
+	}
+	if denyNetworkType(reqWr, &mw.Options, ipStr, networkType) {
+		return
+	}
+	setOverrideLabels(req, mw.Overrides, ip)
+	if networkType == NetworkTypePublic || mw.Overrides.Match(ip) != nil {
+		mw.Lookup.ServeHTTP(reqWr, req)
+		return
+	}
+	mw.Options.setHeader(req, FieldIPAddress, ipStr)
+	if denyNotLookedUp(reqWr, &mw.Options, ipStr, networkType) {
+		return
+	}
+	mw.Next.ServeHTTP(reqWr, req)
+}
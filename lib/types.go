@@ -1,7 +1,13 @@
 // Package lib package contains traefikgeoip implementations.
 package lib
 
-import "net/http"
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
 
 // TraefikGeoIPBase is a base middleware that looks client IP address from the GeoIP2 database.
 type TraefikGeoIPBase struct {
@@ -18,42 +24,256 @@ type TraefikGeoIPNotFound struct {
 }
 
 func (mw *TraefikGeoIPNotFound) ServeHTTP(reqWr http.ResponseWriter, req *http.Request) {
+	if denyCountry(reqWr, &mw.Options, getClientIP(req, mw.Options), Unknown) {
+		return
+	}
 	mw.Next.ServeHTTP(reqWr, req)
 }
 
 // Options the plugin options.
 type Options struct {
 	PreferXForwardedForHeader bool
-	IPHeader                  string `json:"ipHeader,omitempty"`
-	FailInError               bool   `json:"failInError,omitempty"`
-	Debug                     bool   `json:"debug,omitempty"`
-	LightMode                 bool   `json:"lightMode,omitempty"`
-	Iso88591                  bool   `json:"iso88591,omitempty"`
+	PreferForwardedHeader     bool              `json:"preferForwardedHeader,omitempty"`
+	IPHeader                  string            `json:"ipHeader,omitempty"`
+	IPHeaders                 []string          `json:"ipHeaders,omitempty"`
+	TrustedProxies            []string          `json:"trustedProxies,omitempty"`
+	FailInError               bool              `json:"failInError,omitempty"`
+	Debug                     bool              `json:"debug,omitempty"`
+	LightMode                 bool              `json:"lightMode,omitempty"`
+	Iso88591                  bool              `json:"iso88591,omitempty"`
+	Mmap                      bool              `json:"mmap,omitempty"`
+	Fields                    []string          `json:"fields,omitempty"`
+	HeaderPrefix              string            `json:"headerPrefix,omitempty"`
+	Headers                   map[string]string `json:"headers,omitempty"`
+	Languages                 []string          `json:"languages,omitempty"`
+	LanguageHeaders           bool              `json:"languageHeaders,omitempty"`
+
+	AllowedCountries     []string `json:"allowedCountries,omitempty"`
+	BlockedCountries     []string `json:"blockedCountries,omitempty"`
+	UnknownCountryPolicy string   `json:"unknownCountryPolicy,omitempty"`
+
+	AllowedAsns             []string `json:"allowedAsns,omitempty"`
+	BlockedAsns             []string `json:"blockedAsns,omitempty"`
+	AllowedAsnOrganizations []string `json:"allowedAsnOrganizations,omitempty"`
+	BlockedAsnOrganizations []string `json:"blockedAsnOrganizations,omitempty"`
+	UnknownAsnPolicy        string   `json:"unknownAsnPolicy,omitempty"`
+
+	BlockAnonymous          bool `json:"blockAnonymous,omitempty"`
+	BlockAnonymousVPN       bool `json:"blockAnonymousVpn,omitempty"`
+	BlockHostingProviders   bool `json:"blockHostingProviders,omitempty"`
+	BlockPublicProxies      bool `json:"blockPublicProxies,omitempty"`
+	BlockTorExitNodes       bool `json:"blockTorExitNodes,omitempty"`
+	BlockResidentialProxies bool `json:"blockResidentialProxies,omitempty"`
+
+	AllowedNetworkTypes []string `json:"allowedNetworkTypes,omitempty"`
+	BlockedNetworkTypes []string `json:"blockedNetworkTypes,omitempty"`
+
+	MaxDatabaseAge          string `json:"maxDatabaseAge,omitempty"`
+	StaleDatabasePolicy     string `json:"staleDatabasePolicy,omitempty"`
+	DatabaseBuildDateHeader bool   `json:"databaseBuildDateHeader,omitempty"`
+
+	fields     fieldSet
+	ruleFields fieldSet
+	headers    []string
+
+	ipHeaders      []string
+	trustedProxies []*net.IPNet
+
+	maxDatabaseAge time.Duration
+	databaseBuilds *databaseBuilds
 }
 
 // Config the plugin configuration.
 type Config struct {
-	CityDBPath                string `json:"cityDbPath,omitempty"`
-	AsnDBPath                 string `json:"asnDbPath,omitempty"`
-	CountryDBPath             string `json:"countryDbPath,omitempty"`
+	CityDBPath                string      `json:"cityDbPath,omitempty"`
+	AsnDBPath                 string      `json:"asnDbPath,omitempty"`
+	CountryDBPath             string      `json:"countryDbPath,omitempty"`
+	AnonymousIPDBPath         string      `json:"anonymousIpDbPath,omitempty"`
+	ISPDBPath                 string      `json:"ispDbPath,omitempty"`
+	ConnectionTypeDBPath      string      `json:"connectionTypeDbPath,omitempty"`
+	DomainDBPath              string      `json:"domainDbPath,omitempty"`
+	CityDBPaths               []string    `json:"cityDbPaths,omitempty"`
+	CountryDBPaths            []string    `json:"countryDbPaths,omitempty"`
+	AsnDBPaths                []string    `json:"asnDbPaths,omitempty"`
+	Databases                 []string    `json:"databases,omitempty"`
+	GenericDBs                []GenericDB `json:"genericDbs,omitempty"`
+	Overrides                 []Override  `json:"overrides,omitempty"`
+	OverridesFile             string      `json:"overridesFile,omitempty"`
+	ReloadInterval            string      `json:"reloadInterval,omitempty"`
+	MaxMindAccountID          string      `json:"maxMindAccountId,omitempty"`
+	MaxMindLicenseKey         string      `json:"maxMindLicenseKey,omitempty"`
+	MaxMindEditions           []string    `json:"maxMindEditions,omitempty"`
+	MaxMindBaseURL            string      `json:"maxMindBaseUrl,omitempty"`
+	MaxMindCacheDir           string      `json:"maxMindCacheDir,omitempty"`
+	MaxMindUpdateInterval     string      `json:"maxMindUpdateInterval,omitempty"`
 	PreferXForwardedForHeader bool
-	IPHeader                  string `json:"ipHeader,omitempty"`
-	FailInError               bool   `json:"failInError,omitempty"`
-	Debug                     bool   `json:"debug,omitempty"`
-	LightMode                 bool   `json:"lightMode,omitempty"`
-	Iso88591                  bool   `json:"iso88591,omitempty"`
+	PreferForwardedHeader     bool              `json:"preferForwardedHeader,omitempty"`
+	IPHeader                  string            `json:"ipHeader,omitempty"`
+	IPHeaders                 []string          `json:"ipHeaders,omitempty"`
+	TrustedProxies            []string          `json:"trustedProxies,omitempty"`
+	FailInError               bool              `json:"failInError,omitempty"`
+	Debug                     bool              `json:"debug,omitempty"`
+	LightMode                 bool              `json:"lightMode,omitempty"`
+	Iso88591                  bool              `json:"iso88591,omitempty"`
+	Mmap                      bool              `json:"mmap,omitempty"`
+	Fields                    []string          `json:"fields,omitempty"`
+	HeaderPrefix              string            `json:"headerPrefix,omitempty"`
+	Headers                   map[string]string `json:"headers,omitempty"`
+	Languages                 []string          `json:"languages,omitempty"`
+	LanguageHeaders           bool              `json:"languageHeaders,omitempty"`
+
+	AllowedCountries     []string `json:"allowedCountries,omitempty"`
+	BlockedCountries     []string `json:"blockedCountries,omitempty"`
+	UnknownCountryPolicy string   `json:"unknownCountryPolicy,omitempty"`
+
+	AllowedAsns             []string `json:"allowedAsns,omitempty"`
+	BlockedAsns             []string `json:"blockedAsns,omitempty"`
+	AllowedAsnOrganizations []string `json:"allowedAsnOrganizations,omitempty"`
+	BlockedAsnOrganizations []string `json:"blockedAsnOrganizations,omitempty"`
+	UnknownAsnPolicy        string   `json:"unknownAsnPolicy,omitempty"`
+
+	BlockAnonymous          bool `json:"blockAnonymous,omitempty"`
+	BlockAnonymousVPN       bool `json:"blockAnonymousVpn,omitempty"`
+	BlockHostingProviders   bool `json:"blockHostingProviders,omitempty"`
+	BlockPublicProxies      bool `json:"blockPublicProxies,omitempty"`
+	BlockTorExitNodes       bool `json:"blockTorExitNodes,omitempty"`
+	BlockResidentialProxies bool `json:"blockResidentialProxies,omitempty"`
+
+	AllowedNetworkTypes []string `json:"allowedNetworkTypes,omitempty"`
+	BlockedNetworkTypes []string `json:"blockedNetworkTypes,omitempty"`
+
+	MaxDatabaseAge          string `json:"maxDatabaseAge,omitempty"`
+	StaleDatabasePolicy     string `json:"staleDatabasePolicy,omitempty"`
+	DatabaseBuildDateHeader bool   `json:"databaseBuildDateHeader,omitempty"`
 }
 
 // ConfigToOptions converts the plugin configuration to plugin options.
 func ConfigToOptions(config *Config) Options {
-	return Options{
+	options := Options{
 		PreferXForwardedForHeader: config.PreferXForwardedForHeader,
+		PreferForwardedHeader:     config.PreferForwardedHeader,
 		IPHeader:                  config.IPHeader,
+		IPHeaders:                 config.IPHeaders,
+		TrustedProxies:            config.TrustedProxies,
 		FailInError:               config.FailInError,
 		Debug:                     config.Debug,
 		LightMode:                 config.LightMode,
 		Iso88591:                  config.Iso88591,
+		Mmap:                      config.Mmap,
+		Fields:                    config.Fields,
+		HeaderPrefix:              config.HeaderPrefix,
+		Headers:                   config.Headers,
+		Languages:                 toLanguages(config.Languages),
+		LanguageHeaders:           config.LanguageHeaders,
+
+		AllowedCountries:     toUpper(config.AllowedCountries),
+		BlockedCountries:     toUpper(config.BlockedCountries),
+		UnknownCountryPolicy: config.UnknownCountryPolicy,
+
+		AllowedAsns:             toAsnNumbers(config.AllowedAsns),
+		BlockedAsns:             toAsnNumbers(config.BlockedAsns),
+		AllowedAsnOrganizations: toLower(config.AllowedAsnOrganizations),
+		BlockedAsnOrganizations: toLower(config.BlockedAsnOrganizations),
+		UnknownAsnPolicy:        config.UnknownAsnPolicy,
+
+		BlockAnonymous:          config.BlockAnonymous,
+		BlockAnonymousVPN:       config.BlockAnonymousVPN,
+		BlockHostingProviders:   config.BlockHostingProviders,
+		BlockPublicProxies:      config.BlockPublicProxies,
+		BlockTorExitNodes:       config.BlockTorExitNodes,
+		BlockResidentialProxies: config.BlockResidentialProxies,
+
+		AllowedNetworkTypes: toLower(config.AllowedNetworkTypes),
+		BlockedNetworkTypes: toLower(config.BlockedNetworkTypes),
+
+		MaxDatabaseAge:          config.MaxDatabaseAge,
+		StaleDatabasePolicy:     config.StaleDatabasePolicy,
+		DatabaseBuildDateHeader: config.DatabaseBuildDateHeader,
+	}
+	// invalid fields are rejected by ValidateConfig, all fields are sent otherwise
+	options.fields, _ = parseFields(config.Fields, config.LightMode)
+	options.ruleFields = ruleFields(&options)
+	options.headers, _ = parseHeaders(config.HeaderPrefix, config.Headers)
+	options.ipHeaders = toIPHeaders(config.IPHeader, config.IPHeaders)
+	options.trustedProxies, _ = parseNetworks(config.TrustedProxies)
+	options.maxDatabaseAge, _ = time.ParseDuration(config.MaxDatabaseAge)
+	options.databaseBuilds = newDatabaseBuilds()
+	if config.DatabaseBuildDateHeader && options.fields != nil {
+		options.fields[FieldDatabaseBuildDate] = true
 	}
+	return options
+}
+
+// ValidateConfig checks the plugin configuration values that can't be fixed at request time.
+func ValidateConfig(config *Config) error {
+	switch config.UnknownCountryPolicy {
+	case "", PolicyAllow, PolicyBlock:
+	default:
+		return fmt.Errorf("invalid unknownCountryPolicy: %q, expected %q or %q", config.UnknownCountryPolicy, PolicyAllow, PolicyBlock)
+	}
+	switch config.UnknownAsnPolicy {
+	case "", PolicyAllow, PolicyBlock:
+	default:
+		return fmt.Errorf("invalid unknownAsnPolicy: %q, expected %q or %q", config.UnknownAsnPolicy, PolicyAllow, PolicyBlock)
+	}
+	if _, err := parseFields(config.Fields, config.LightMode); err != nil {
+		return err
+	}
+	if _, err := parseHeaders(config.HeaderPrefix, config.Headers); err != nil {
+		return err
+	}
+	if _, err := parseNetworks(config.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trustedProxies: %w", err)
+	}
+	if config.ReloadInterval != "" {
+		if interval, err := time.ParseDuration(config.ReloadInterval); err != nil || interval <= 0 {
+			return fmt.Errorf("invalid reloadInterval: %q, expected a duration like 1m", config.ReloadInterval)
+		}
+	}
+	if config.MaxDatabaseAge != "" {
+		if age, err := time.ParseDuration(config.MaxDatabaseAge); err != nil || age <= 0 {
+			return fmt.Errorf("invalid maxDatabaseAge: %q, expected a duration like 720h", config.MaxDatabaseAge)
+		}
+	}
+	switch config.StaleDatabasePolicy {
+	case "", StalePolicyWarn, StalePolicyDegrade, StalePolicyFail:
+	default:
+		return fmt.Errorf("invalid staleDatabasePolicy: %q, expected %q, %q or %q", config.StaleDatabasePolicy,
+			StalePolicyWarn, StalePolicyDegrade, StalePolicyFail)
+	}
+	if err := validateMaxMindConfig(config); err != nil {
+		return err
+	}
+	if err := validateGenericDBs(config.GenericDBs); err != nil {
+		return err
+	}
+	for _, networkType := range toLower(config.AllowedNetworkTypes) {
+		if !isNetworkType(networkType) {
+			return fmt.Errorf("invalid allowedNetworkTypes: %q, expected %s, %s, %s, %s or %s", networkType,
+				NetworkTypePublic, NetworkTypePrivate, NetworkTypeLoopback, NetworkTypeCGNAT, NetworkTypeReserved)
+		}
+	}
+	for _, networkType := range toLower(config.BlockedNetworkTypes) {
+		if !isNetworkType(networkType) {
+			return fmt.Errorf("invalid blockedNetworkTypes: %q, expected %s, %s, %s, %s or %s", networkType,
+				NetworkTypePublic, NetworkTypePrivate, NetworkTypeLoopback, NetworkTypeCGNAT, NetworkTypeReserved)
+		}
+	}
+	for _, asn := range toAsnNumbers(append(append([]string{}, config.AllowedAsns...), config.BlockedAsns...)) {
+		if _, err := strconv.ParseUint(asn, 10, 32); err != nil {
+			return fmt.Errorf("invalid ASN: %q", asn)
+		}
+	}
+	return nil
+}
+
+// ReloadInterval returns how often the DB files are checked for changes, 0 when they are not reloaded.
+func ReloadInterval(config *Config) time.Duration {
+	interval, err := time.ParseDuration(config.ReloadInterval)
+	if err != nil {
+		return 0
+	}
+	return interval
 }
 
 // DefaultDBPath default GeoIP2 database path.
@@ -70,10 +290,26 @@ const (
 	CountryHeader = "GeoIP-Country"
 	// CountryCodeHeader country code header name.
 	CountryCodeHeader = "GeoIP-Country-Code"
+	// IsInEuropeanUnionHeader european union member country header name.
+	IsInEuropeanUnionHeader = "GeoIP-Is-In-European-Union"
+	// RegisteredCountryHeader country where the ip is registered header name.
+	RegisteredCountryHeader = "GeoIP-Registered-Country"
+	// RegisteredCountryCodeHeader country code where the ip is registered header name.
+	RegisteredCountryCodeHeader = "GeoIP-Registered-Country-Code"
+	// RegisteredCountryMismatchHeader registered country differs from the located country header name.
+	RegisteredCountryMismatchHeader = "GeoIP-Registered-Country-Mismatch"
+	// RepresentedCountryHeader country represented by the users of the ip (e.g. military base) header name.
+	RepresentedCountryHeader = "GeoIP-Represented-Country"
+	// RepresentedCountryCodeHeader represented country code header name.
+	RepresentedCountryCodeHeader = "GeoIP-Represented-Country-Code"
 	// RegionHeader region header name.
 	RegionHeader = "GeoIP-Region"
 	// RegionCodeHeader region code header name.
 	RegionCodeHeader = "GeoIP-Region-Code"
+	// SubdivisionsHeader all subdivisions, from the largest to the smallest, header name.
+	SubdivisionsHeader = "GeoIP-Subdivisions"
+	// SubdivisionCodesHeader all subdivision codes, from the largest to the smallest, header name.
+	SubdivisionCodesHeader = "GeoIP-Subdivision-Codes"
 	// CityHeader city header name.
 	CityHeader = "GeoIP-City"
 	// PostalCodeHeader city header name.
@@ -87,12 +323,68 @@ const (
 	AccuracyRadiusHeader = "GeoIP-Accuracy-Radius"
 	// GeohashHeader geohash header name.
 	GeohashHeader = "GeoIP-Geohash"
+	// TimeZoneHeader time zone header name.
+	TimeZoneHeader = "GeoIP-Time-Zone"
+	// MetroCodeHeader metro code header name.
+	MetroCodeHeader = "GeoIP-Metro-Code"
 
 	// ASNSystemNumberHeader asn system number header name.
 	ASNSystemNumberHeader = "GeoIP-ASN-System-Number"
 	// ASNOrganizationHeader asn system organization header name.
 	ASNOrganizationHeader = "GeoIP-ASN-Organization"
 
+	// CountryConfidenceHeader country confidence header name.
+	CountryConfidenceHeader = "GeoIP-Country-Confidence"
+	// RegionConfidenceHeader region confidence header name.
+	RegionConfidenceHeader = "GeoIP-Region-Confidence"
+	// CityConfidenceHeader city confidence header name.
+	CityConfidenceHeader = "GeoIP-City-Confidence"
+	// PostalConfidenceHeader postal code confidence header name.
+	PostalConfidenceHeader = "GeoIP-Postal-Confidence"
+	// UserTypeHeader user type header name.
+	UserTypeHeader = "GeoIP-User-Type"
+	// StaticIPScoreHeader static ip score header name.
+	StaticIPScoreHeader = "GeoIP-Static-IP-Score"
+	// IsLegitimateProxyHeader legitimate proxy header name.
+	IsLegitimateProxyHeader = "GeoIP-Is-Legitimate-Proxy"
+
+	// ISPHeader isp header name.
+	ISPHeader = "GeoIP-ISP"
+	// OrganizationHeader isp organization header name.
+	OrganizationHeader = "GeoIP-Organization"
+	// MobileCountryCodeHeader mobile country code (MCC) header name.
+	MobileCountryCodeHeader = "GeoIP-Mobile-Country-Code"
+	// MobileNetworkCodeHeader mobile network code (MNC) header name.
+	MobileNetworkCodeHeader = "GeoIP-Mobile-Network-Code"
+
+	// ConnectionTypeHeader connection type header name.
+	ConnectionTypeHeader = "GeoIP-Connection-Type"
+	// DomainHeader domain header name.
+	DomainHeader = "GeoIP-Domain"
+
+	// IsAnonymousHeader anonymous ip header name.
+	IsAnonymousHeader = "GeoIP-Is-Anonymous"
+	// IsAnonymousVPNHeader anonymous vpn header name.
+	IsAnonymousVPNHeader = "GeoIP-Is-VPN"
+	// IsHostingProviderHeader hosting provider header name.
+	IsHostingProviderHeader = "GeoIP-Is-Hosting"
+	// IsPublicProxyHeader public proxy header name.
+	IsPublicProxyHeader = "GeoIP-Is-Public-Proxy"
+	// IsTorExitNodeHeader tor exit node header name.
+	IsTorExitNodeHeader = "GeoIP-Is-Tor-Exit"
+	// IsResidentialProxyHeader residential proxy header name.
+	IsResidentialProxyHeader = "GeoIP-Is-Residential-Proxy"
+
+	// NetworkTypeHeader network type (public, private, loopback, cgnat or reserved) header name.
+	NetworkTypeHeader = "GeoIP-Network-Type"
+
+	// SourceHeader DB types that answered the location header name.
+	SourceHeader = "GeoIP-Source"
+	// ASNSourceHeader DB types that answered the ASN header name.
+	ASNSourceHeader = "GeoIP-ASN-Source"
+	// DatabaseBuildDateHeader build date of the oldest DB header name.
+	DatabaseBuildDateHeader = "GeoIP-DB-Build-Date"
+
 	// IPAddressHeader up used in geoip header name.
 	IPAddressHeader = "GeoIP-IPAddress"
 )
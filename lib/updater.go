@@ -0,0 +1,363 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxMindBaseURL MaxMind download server.
+const DefaultMaxMindBaseURL = "https://download.maxmind.com"
+
+// maxDownloadSize upper bound of a downloaded edition, the GeoIP2 Enterprise tar.gz is about 500MB.
+const maxDownloadSize = 1 << 30
+
+// Updater downloads MaxMind editions to a cache directory.
+type Updater struct {
+	AccountID  string
+	LicenseKey string
+	Editions   []string
+	BaseURL    string
+	CacheDir   string
+	Client     *http.Client
+}
+
+// NewUpdater creates the updater of the configured editions, nil when no edition is configured.
+func NewUpdater(config *Config) *Updater {
+	if len(config.MaxMindEditions) == 0 {
+		return nil
+	}
+	updater := &Updater{
+		AccountID:  config.MaxMindAccountID,
+		LicenseKey: config.MaxMindLicenseKey,
+		Editions:   config.MaxMindEditions,
+		BaseURL:    strings.TrimRight(config.MaxMindBaseURL, "/"),
+		CacheDir:   config.MaxMindCacheDir,
+		Client:     &http.Client{Timeout: 10 * time.Minute},
+	}
+	if updater.BaseURL == "" {
+		updater.BaseURL = DefaultMaxMindBaseURL
+	}
+	if updater.CacheDir == "" {
+		updater.CacheDir = filepath.Join(os.TempDir(), "traefikgeoip")
+	}
+	return updater
+}
+
+// DBPath path of the downloaded edition.
+func (updater *Updater) DBPath(edition string) string {
+	return filepath.Join(updater.CacheDir, edition+".mmdb")
+}
+
+// ApplyPaths sets the DB paths left empty in config to the downloaded editions.
+func (updater *Updater) ApplyPaths(config *Config) {
+	for _, edition := range updater.Editions {
+		if dbPath := editionDBPath(config, edition); dbPath != nil && *dbPath == "" {
+			*dbPath = updater.DBPath(edition)
+		}
+	}
+}
+
+// Update downloads the editions that changed, the cached ones are kept when the download fails.
+func (updater *Updater) Update(ctx context.Context) error {
+	var failed []string
+	for _, edition := range updater.Editions {
+		updated, err := updater.Download(ctx, edition)
+		if err != nil {
+			log.Printf("[geoip2] MaxMind edition update failed: edition=%s, err=%v", edition, err)
+			failed = append(failed, edition)
+		} else if updated {
+			log.Printf("[geoip2] MaxMind edition updated: edition=%s, db=%s", edition, updater.DBPath(edition))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("MaxMind editions update failed: editions=%s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// UpdateOnStartup updates the editions once per cache dir however many instances start. An edition with a cached
+// copy is updated in the background, the copy is used until the update is reloaded; the instances wait for the
+// download of a missing edition until ctx is done.
+func (updater *Updater) UpdateOnStartup(ctx context.Context) error {
+	var failed []string
+	for _, edition := range updater.Editions {
+		update := updater.startupUpdate(edition)
+		if _, err := os.Stat(updater.DBPath(edition)); err == nil {
+			continue
+		}
+		select {
+		case <-update.done:
+			if update.err != nil {
+				failed = append(failed, edition)
+			}
+		case <-ctx.Done():
+			failed = append(failed, edition)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("MaxMind editions download failed: editions=%s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// startupUpdate the first update of an edition in the process, done is closed once err is set.
+type startupUpdate struct {
+	done chan struct{}
+	err  error
+}
+
+// startupUpdates first update of each edition, keyed by DB path. A failed update is removed, the next instance
+// retries it.
+//
+//nolint:gochecknoglobals
+var startupUpdates = struct {
+	sync.Mutex
+	updates map[string]*startupUpdate
+}{updates: map[string]*startupUpdate{}}
+
+// startupUpdate starts the first update of the edition unless another instance did.
+func (updater *Updater) startupUpdate(edition string) *startupUpdate {
+	key := updater.DBPath(edition)
+
+	startupUpdates.Lock()
+	defer startupUpdates.Unlock()
+	if update, ok := startupUpdates.updates[key]; ok {
+		return update
+	}
+	update := &startupUpdate{done: make(chan struct{})}
+	startupUpdates.updates[key] = update
+	editionUpdater := *updater
+	editionUpdater.Editions = []string{edition}
+	go func() {
+		// not bound to the ctx of an instance, the others may wait for it
+		update.err = editionUpdater.Update(context.Background())
+		if update.err != nil {
+			startupUpdates.Lock()
+			if startupUpdates.updates[key] == update {
+				delete(startupUpdates.updates, key)
+			}
+			startupUpdates.Unlock()
+		}
+		close(update.done)
+	}()
+	return update
+}
+
+// Start updates the editions every interval until ctx is done. An edition is updated by a single ticker per cache
+// dir, shared by every instance of the process: the first instance to start it sets its interval and credentials,
+// it stops once no instance holds it.
+func (updater *Updater) Start(ctx context.Context, interval time.Duration) {
+	for _, edition := range updater.Editions {
+		releaseOnDone(ctx, updater.startEdition(edition, interval))
+	}
+}
+
+// sharedUpdate an edition updated in the background for the middleware instances of the process.
+type sharedUpdate struct {
+	cancel context.CancelFunc
+	refs   int
+}
+
+// sharedUpdates editions updated in the background, keyed by DB path.
+//
+//nolint:gochecknoglobals
+var sharedUpdates = struct {
+	sync.Mutex
+	updates map[string]*sharedUpdate
+}{updates: map[string]*sharedUpdate{}}
+
+// startEdition starts the ticker of the edition unless another instance holds it, release drops the reference.
+func (updater *Updater) startEdition(edition string, interval time.Duration) func() {
+	key := updater.DBPath(edition)
+
+	sharedUpdates.Lock()
+	defer sharedUpdates.Unlock()
+	update, ok := sharedUpdates.updates[key]
+	if !ok {
+		editionUpdater := *updater
+		editionUpdater.Editions = []string{edition}
+		ctx, cancel := context.WithCancel(context.Background())
+		update = &sharedUpdate{cancel: cancel}
+		sharedUpdates.updates[key] = update
+		go editionUpdater.run(ctx, interval)
+	}
+	update.refs++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sharedUpdates.Lock()
+			defer sharedUpdates.Unlock()
+			update.refs--
+			if update.refs == 0 && sharedUpdates.updates[key] == update {
+				update.cancel()
+				delete(sharedUpdates.updates, key)
+			}
+		})
+	}
+}
+
+// run updates the editions every interval until ctx is done.
+func (updater *Updater) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = updater.Update(ctx)
+		}
+	}
+}
+
+// Download downloads the edition when its SHA256 differs from the cached one, and reports whether it did.
+func (updater *Updater) Download(ctx context.Context, edition string) (bool, error) {
+	if err := os.MkdirAll(updater.CacheDir, 0o755); err != nil {
+		return false, fmt.Errorf("cache dir not created: %w", err)
+	}
+	checksumBody, err := updater.get(ctx, edition, "tar.gz.sha256")
+	if err != nil {
+		return false, err
+	}
+	fields := strings.Fields(string(checksumBody))
+	if len(fields) == 0 {
+		return false, errors.New("empty SHA256 file")
+	}
+	checksum := strings.ToLower(fields[0])
+	checksumPath := filepath.Join(updater.CacheDir, edition+".tar.gz.sha256")
+	if cached, err := os.ReadFile(checksumPath); err == nil && string(cached) == checksum {
+		if _, err := os.Stat(updater.DBPath(edition)); err == nil {
+			return false, nil
+		}
+	}
+
+	archive, err := updater.get(ctx, edition, "tar.gz")
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(archive)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return false, fmt.Errorf("SHA256 mismatch: expected=%s, got=%s", checksum, hex.EncodeToString(sum[:]))
+	}
+	db, err := extractMMDB(archive)
+	if err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(updater.DBPath(edition), db); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(checksumPath, []byte(checksum), 0o644); err != nil { //nolint:gosec
+		return true, fmt.Errorf("SHA256 not cached: %w", err)
+	}
+	return true, nil
+}
+
+func (updater *Updater) get(ctx context.Context, edition, suffix string) ([]byte, error) {
+	downloadURL := fmt.Sprintf("%s/geoip/databases/%s/download?suffix=%s", updater.BaseURL, url.PathEscape(edition), url.QueryEscape(suffix))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	req.SetBasicAuth(updater.AccountID, updater.LicenseKey)
+	res, err := updater.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: suffix=%s, status=%s", suffix, res.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxDownloadSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return body, nil
+}
+
+// writeFileAtomic replaces the file with a rename, so the DB reload never reads a half written file.
+// The temporary file is unique, so the instances updating the same cache dir don't write over each other.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644) //nolint:gosec
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// editionDBPath returns the DB path option matching the edition, nil when the edition is unknown.
+func editionDBPath(config *Config, edition string) *string {
+	switch {
+	case strings.HasSuffix(edition, "-City"), strings.HasSuffix(edition, "-Enterprise"):
+		return &config.CityDBPath
+	case strings.HasSuffix(edition, "-Country"):
+		return &config.CountryDBPath
+	case strings.HasSuffix(edition, "-ASN"):
+		return &config.AsnDBPath
+	case strings.HasSuffix(edition, "-ISP"):
+		return &config.ISPDBPath
+	case strings.HasSuffix(edition, "-Anonymous-IP"):
+		return &config.AnonymousIPDBPath
+	case strings.HasSuffix(edition, "-Connection-Type"):
+		return &config.ConnectionTypeDBPath
+	case strings.HasSuffix(edition, "-Domain"):
+		return &config.DomainDBPath
+	default:
+		return nil
+	}
+}
+
+func validateMaxMindConfig(config *Config) error {
+	if len(config.MaxMindEditions) == 0 {
+		return nil
+	}
+	if config.MaxMindAccountID == "" || config.MaxMindLicenseKey == "" {
+		return errors.New("maxMindEditions need maxMindAccountId and maxMindLicenseKey")
+	}
+	for _, edition := range config.MaxMindEditions {
+		if editionDBPath(config, edition) == nil {
+			return fmt.Errorf("unknown MaxMind edition: %q", edition)
+		}
+	}
+	if config.MaxMindUpdateInterval != "" {
+		if interval, err := time.ParseDuration(config.MaxMindUpdateInterval); err != nil || interval <= 0 {
+			return fmt.Errorf("invalid maxMindUpdateInterval: %q, expected a duration like 24h", config.MaxMindUpdateInterval)
+		}
+	}
+	return nil
+}
+
+// MaxMindUpdateInterval returns how often the editions are updated, 0 when they are only downloaded at startup.
+func MaxMindUpdateInterval(config *Config) time.Duration {
+	interval, err := time.ParseDuration(config.MaxMindUpdateInterval)
+	if err != nil {
+		return 0
+	}
+	return interval
+}
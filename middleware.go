@@ -6,10 +6,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	lib "github.com/thiagotognoli/traefikgeoip/lib"
 )
 
+// defaultUpdateReloadInterval reload interval of the DBs downloaded by the MaxMind updater.
+const defaultUpdateReloadInterval = "1m"
+
 // ResetLookup reset lookup function.
 func ResetLookup() {
 	// lookupAsn = nil
@@ -27,8 +31,28 @@ func CreateConfig() *lib.Config {
 // New created a new TraefikGeoIP plugin.
 //
 //nolint:gocyclo
-func New(_ context.Context, next http.Handler, cfg *lib.Config, name string) (http.Handler, error) {
-	lookupCity, lookupCountry, lookupAsn, err := factoryLookups(cfg, name)
+func New(ctx context.Context, next http.Handler, cfg *lib.Config, name string) (http.Handler, error) {
+	if err := lib.ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+	overrides, err := lib.LoadOverrides(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if updater := lib.NewUpdater(cfg); updater != nil {
+		cfg = downloadEditions(ctx, cfg, updater)
+	}
+	options := lib.ConfigToOptions(cfg)
+	// the DBs and their reload goroutines are released with lookupCtx when the lookups end up unused, e.g. on a
+	// stale DB, they are released with ctx otherwise
+	lookupCtx, release := context.WithCancel(ctx)
+	inUse := false
+	defer func() {
+		if !inUse {
+			release()
+		}
+	}()
+	lookups, err := factoryLookups(lookupCtx, cfg, &options, name)
 	if err != nil {
 		if cfg.FailInError {
 			log.Fatalf("%s", err.Error())
@@ -37,100 +61,315 @@ func New(_ context.Context, next http.Handler, cfg *lib.Config, name string) (ht
 
 		stderrLogger := log.New(os.Stderr, "ERROR: ", log.LstdFlags|log.Lshortfile)
 		stderrLogger.Printf("%s. Only processing IpHeader.", err.Error())
-		return &lib.TraefikGeoIP{
+		return newNoLookupHandler(next, options, name), nil // err
+	}
+	if err := options.CheckDatabaseAge(); err != nil {
+		switch cfg.StaleDatabasePolicy {
+		case lib.StalePolicyFail:
+			return nil, err
+		case lib.StalePolicyDegrade:
+			log.Printf("[geoip2] %s. Only processing IpHeader: name=%s", err.Error(), name)
+			return newNoLookupHandler(next, options, name), nil
+		}
+	}
+
+	if overrides != nil {
+		lookups.override(overrides)
+	}
+	if lookups.city == nil && lookups.country == nil && options.HasCountryRules() {
+		log.Printf("[geoip2] Country rules need a City or Country DB, every country is unknown: name=%s", name)
+	}
+	if lookups.asn == nil && options.HasAsnRules() {
+		log.Printf("[geoip2] ASN rules need an ASN or ISP DB, they are ignored: name=%s", name)
+	}
+
+	handler := newLocationHandler(next, options, name, lookups)
+	if lookups.connectionType != nil {
+		handler = &lib.TraefikGeoIPConnectionType{
+			Next:                 handler,
+			Name:                 name,
+			Options:              options,
+			LookupConnectionType: lookups.connectionType,
+		}
+	}
+	if lookups.domain != nil {
+		handler = &lib.TraefikGeoIPDomain{
+			Next:         handler,
+			Name:         name,
+			Options:      options,
+			LookupDomain: lookups.domain,
+		}
+	}
+	for i, lookup := range lookups.generic {
+		// validated by ValidateConfig
+		headers, _ := lib.ParseGenericHeaders(cfg.GenericDBs[i].Headers)
+		handler = &lib.TraefikGeoIPGeneric{
+			Next:          handler,
+			Name:          name,
+			Options:       options,
+			LookupGeneric: lookup,
+			Headers:       headers,
+		}
+	}
+	if lookups.isp != nil && !lookups.ispAsn &&
+		options.Wants(lib.FieldISP, lib.FieldOrganization, lib.FieldMobileCountryCode, lib.FieldMobileNetworkCode) {
+		handler = &lib.TraefikGeoIPIsp{
+			Next:      handler,
+			Name:      name,
+			Options:   options,
+			LookupIsp: lookups.isp,
+		}
+	}
+	if lookups.anonymous != nil {
+		handler = &lib.TraefikGeoIPAnonymous{
+			Next:            handler,
+			Name:            name,
+			Options:         options,
+			LookupAnonymous: lookups.anonymous,
+		}
+	}
+	inUse = true
+	// private, loopback, CGNAT and reserved addresses skip the lookups
+	return &lib.TraefikGeoIPNetworkType{
+		Next:      next,
+		Lookup:    handler,
+		Name:      name,
+		Options:   options,
+		Overrides: overrides,
+	}, nil
+}
+
+// newNoLookupHandler only processes the IP headers, used when the DBs can't be used.
+func newNoLookupHandler(next http.Handler, options lib.Options, name string) http.Handler {
+	return &lib.TraefikGeoIPNetworkType{
+		Next: next,
+		Lookup: &lib.TraefikGeoIP{
 			Next:    next,
 			Name:    name,
-			Options: lib.ConfigToOptions(cfg),
-		}, nil // err
+			Options: options,
+		},
+		Name:    name,
+		Options: options,
 	}
+}
 
-	switch {
-	case cfg.LightMode && lookupCity != nil && lookupAsn != nil:
-		return &lib.TraefikGeoIPCityAsnLightMode{
-			Next:       next,
-			Name:       name,
-			Options:    lib.ConfigToOptions(cfg),
-			LookupAsn:  lookupAsn,
-			LookupCity: lookupCity,
-		}, nil
+// downloadEditions downloads the MaxMind editions missing from the cache, the cached ones are updated in the
+// background, and returns a copy of cfg with the DB paths set to them.
+func downloadEditions(ctx context.Context, cfg *lib.Config, updater *lib.Updater) *lib.Config {
+	updated := *cfg
+	updater.ApplyPaths(&updated)
+	if err := updater.UpdateOnStartup(ctx); err != nil {
+		log.Printf("[geoip2] %v", err)
+	}
+	if interval := lib.MaxMindUpdateInterval(cfg); interval > 0 {
+		updater.Start(ctx, interval)
+	}
+	if updated.ReloadInterval == "" {
+		// the updated editions are only used when the DBs are reloaded
+		updated.ReloadInterval = defaultUpdateReloadInterval
+	}
+	return &updated
+}
 
+// newLocationHandler picks the middleware matching the City, Country and ASN DBs found.
+func newLocationHandler(next http.Handler, options lib.Options, name string, lookups *lookups) http.Handler {
+	lookupCity, lookupCountry, lookupAsn := lookups.city, lookups.country, lookups.asn
+	switch {
 	case lookupCity != nil && lookupAsn != nil:
 		return &lib.TraefikGeoIPCityAsn{
 			Next:       next,
 			Name:       name,
-			Options:    lib.ConfigToOptions(cfg),
+			Options:    options,
 			LookupAsn:  lookupAsn,
 			LookupCity: lookupCity,
-		}, nil
-	case cfg.LightMode && lookupCity != nil:
-		return &lib.TraefikGeoIPCityLightMode{
-			Next:       next,
-			Name:       name,
-			Options:    lib.ConfigToOptions(cfg),
-			LookupCity: lookupCity,
-		}, nil
+		}
 	case lookupCity != nil:
 		return &lib.TraefikGeoIPCity{
 			Next:       next,
 			Name:       name,
-			Options:    lib.ConfigToOptions(cfg),
+			Options:    options,
 			LookupCity: lookupCity,
-		}, nil
+		}
 	case lookupCountry != nil && lookupAsn != nil:
 		return &lib.TraefikGeoIPCountryAsn{
 			Next:          next,
 			Name:          name,
-			Options:       lib.ConfigToOptions(cfg),
+			Options:       options,
 			LookupAsn:     lookupAsn,
 			LookupCountry: lookupCountry,
-		}, nil
+		}
 	case lookupCountry != nil:
 		return &lib.TraefikGeoIPCountry{
 			Next:          next,
 			Name:          name,
-			Options:       lib.ConfigToOptions(cfg),
+			Options:       options,
 			LookupCountry: lookupCountry,
-		}, nil
+		}
 	case lookupAsn != nil:
 		return &lib.TraefikGeoIPAsn{
 			Next:      next,
 			Name:      name,
-			Options:   lib.ConfigToOptions(cfg),
+			Options:   options,
 			LookupAsn: lookupAsn,
-		}, nil
+		}
 	default:
 		return &lib.TraefikGeoIPNotFound{
 			Next:    next,
 			Name:    name,
-			Options: lib.ConfigToOptions(cfg),
-		}, nil // fmt.Errorf("none GeoIP DB configured")
+			Options: options,
+		} // fmt.Errorf("none GeoIP DB configured")
 	}
 }
 
-func factoryLookups(cfg *lib.Config, name string) (lib.LookupGeoIPCity, lib.LookupGeoIPCountry, lib.LookupGeoIPAsn, error) {
-	var lookupCity lib.LookupGeoIPCity
-	var lookupCountry lib.LookupGeoIPCountry
-	var lookupAsn lib.LookupGeoIPAsn
+// lookups holds the lookup of each configured DB, nil when the DB is not configured.
+type lookups struct {
+	city      lib.LookupGeoIPCity
+	country   lib.LookupGeoIPCountry
+	asn       lib.LookupGeoIPAsn
+	anonymous lib.LookupGeoIPAnonymous
+	isp       lib.LookupGeoIPIsp
+	// ispAsn tells the ISP DB is the first DB of the ASN chain, the ISP headers are set with the ASN ones
+	ispAsn bool
 
-	if cfg.CityDBPath != "" {
-		var err error
-		lookupCity, err = lib.NewLookupCity(cfg.CityDBPath, name, cfg.Iso88591)
+	connectionType lib.LookupGeoIPConnectionType
+	domain         lib.LookupGeoIPDomain
+	// generic lookup of each genericDbs entry, in order
+	generic []lib.LookupGeoIPGeneric
+}
+
+// override checks the overrides before the City, Country and ASN lookups,
+// the overrides alone are used when none of these DBs is configured.
+func (result *lookups) override(overrides *lib.OverrideTable) {
+	switch {
+	case result.city != nil:
+		result.city = lib.CreateOverrideCityLookup(overrides, result.city)
+	case result.country != nil:
+		result.country = lib.CreateOverrideCountryLookup(overrides, result.country)
+	case overrides.HasLocation():
+		result.city = lib.CreateOverrideCityLookup(overrides, nil)
+	}
+	if result.asn != nil || overrides.HasAsn() {
+		result.asn = lib.CreateOverrideAsnLookup(overrides, result.asn)
+	}
+}
+
+// factoryLookups opens the configured DBs holding at least one of the wanted fields.
+//
+//nolint:gocyclo
+func factoryLookups(ctx context.Context, cfg *lib.Config, options *lib.Options, name string) (*lookups, error) {
+	cfg, err := lib.DetectDatabases(cfg, name)
+	if err != nil {
+		return nil, err
+	}
+	interval := lib.ReloadInterval(cfg)
+	result := &lookups{}
+	err = factoryLocationLookups(ctx, cfg, options, name, result)
+	if err != nil {
+		return nil, err
+	}
+	if err = factoryAsnLookups(ctx, cfg, options, name, result); err != nil {
+		return nil, err
+	}
+	if cfg.AnonymousIPDBPath != "" && options.Wants(lib.FieldIsAnonymous, lib.FieldIsAnonymousVPN, lib.FieldIsHostingProvider,
+		lib.FieldIsPublicProxy, lib.FieldIsTorExitNode, lib.FieldIsResidentialProxy) {
+		result.anonymous, err = lib.NewReloadingLookupAnonymous(ctx, cfg.AnonymousIPDBPath, name, options, interval)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
-	} else if cfg.CountryDBPath != "" {
-		var err error
-		lookupCountry, err = lib.NewLookupCountry(cfg.CountryDBPath, name, cfg.Iso88591)
+	}
+	if cfg.ConnectionTypeDBPath != "" && options.Wants(lib.FieldConnectionType) {
+		result.connectionType, err = lib.NewReloadingLookupConnectionType(ctx, cfg.ConnectionTypeDBPath, name, options, interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.DomainDBPath != "" && options.Wants(lib.FieldDomain) {
+		result.domain, err = lib.NewReloadingLookupDomain(ctx, cfg.DomainDBPath, name, options, interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, db := range cfg.GenericDBs {
+		lookup, err := lib.NewReloadingLookupGeneric(ctx, db.Path, name, options, interval)
+		if err != nil {
+			return nil, err
+		}
+		result.generic = append(result.generic, lookup)
+	}
+	return result, nil
+}
+
+// factoryLocationLookups opens the City DBs followed by the Country DBs as a fallback chain, each field comes from
+// the first DB that has it. The Country lookup is only used when there is no City DB.
+func factoryLocationLookups(ctx context.Context, cfg *lib.Config, options *lib.Options, name string, result *lookups) error {
+	interval := lib.ReloadInterval(cfg)
+	var cities []lib.LookupGeoIPCity
+	for _, dbPath := range dbPaths(cfg.CityDBPath, cfg.CityDBPaths) {
+		lookup, err := lib.NewReloadingLookupCity(ctx, dbPath, name, options, interval)
 		if err != nil {
-			return nil, nil, nil, err
+			return err
+		}
+		cities = append(cities, lookup)
+	}
+	var countries []lib.LookupGeoIPCountry
+	for _, dbPath := range dbPaths(cfg.CountryDBPath, cfg.CountryDBPaths) {
+		lookup, err := lib.NewReloadingLookupCountry(ctx, dbPath, name, options, interval)
+		if err != nil {
+			return err
+		}
+		countries = append(countries, lookup)
+	}
+	switch {
+	case len(cities) > 0:
+		for _, lookup := range countries {
+			cities = append(cities, lib.CreateCountryCityLookup(lookup))
 		}
+		result.city = lib.CreateFallbackCityLookup(cities, options)
+	case len(countries) > 0:
+		result.country = lib.CreateFallbackCountryLookup(countries, options)
 	}
-	if cfg.AsnDBPath != "" {
+	return nil
+}
+
+// factoryAsnLookups opens the ISP DB followed by the ASN DBs as a fallback chain of the ASN data.
+func factoryAsnLookups(ctx context.Context, cfg *lib.Config, options *lib.Options, name string, result *lookups) error {
+	interval := lib.ReloadInterval(cfg)
+	wantsAsn := options.Wants(lib.FieldASN, lib.FieldASNOrganization)
+	wantsIsp := options.Wants(lib.FieldISP, lib.FieldOrganization, lib.FieldMobileCountryCode, lib.FieldMobileNetworkCode)
+	var asns []lib.LookupGeoIPAsn
+	if cfg.ISPDBPath != "" && (wantsAsn || wantsIsp) {
 		var err error
-		lookupAsn, err = lib.NewLookupAsn(cfg.AsnDBPath, name, cfg.Iso88591)
+		result.isp, err = lib.NewReloadingLookupIsp(ctx, cfg.ISPDBPath, name, options, interval)
+		if err != nil {
+			return err
+		}
+		// the ISP DB carries the ASN data, the ASN DBs only fill the IPs it doesn't know
+		asns = append(asns, lib.CreateIspAsnLookup(result.isp))
+	}
+	if !wantsAsn {
+		return nil
+	}
+	result.ispAsn = result.isp != nil
+	for _, dbPath := range dbPaths(cfg.AsnDBPath, cfg.AsnDBPaths) {
+		lookup, err := lib.NewReloadingLookupAsn(ctx, dbPath, name, options, interval)
 		if err != nil {
-			return nil, nil, nil, err
+			return err
+		}
+		asns = append(asns, lookup)
+	}
+	if len(asns) > 0 {
+		result.asn = lib.CreateFallbackAsnLookup(asns)
+	}
+	return nil
+}
+
+// dbPaths returns the DB path option followed by the DB paths list option, skipping the empty ones.
+func dbPaths(dbPath string, paths []string) []string {
+	var result []string
+	for _, path := range append([]string{dbPath}, paths...) {
+		if path = strings.TrimSpace(path); path != "" {
+			result = append(result, path)
 		}
 	}
-	return lookupCity, lookupCountry, lookupAsn, nil
+	return result
 }
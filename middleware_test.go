@@ -1,11 +1,25 @@
 package traefikgeoip_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	mw "github.com/thiagotognoli/traefikgeoip"
@@ -166,6 +180,801 @@ func TestGeoIPFromXForwardedFor(t *testing.T) {
 	assertHeader(t, req, lmw.IPAddressHeader, "qwerty")
 }
 
+func TestGeoIPTrustedProxies(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+	mwCfg.PreferXForwardedForHeader = true
+	mwCfg.TrustedProxies = []string{"10.0.0.0/8", "2001:db8::/32", "192.168.1.1"}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor []string
+		expectedIP   string
+		expectedCity string
+	}{
+		{"10.0.0.1:9999", []string{"1.2.3.4, " + ValidIP + ", 10.0.0.2"}, ValidIP, "Munich"},
+		{"10.0.0.1:9999", []string{"1.2.3.4, " + ValidIP, "10.0.0.2:8080"}, ValidIP, "Munich"},
+		{"[2001:db8::1]:9999", []string{ValidIP + ":1234", "[2001:db8::2]:443"}, ValidIP, "Munich"},
+		{"192.168.1.1:9999", []string{ValidIPNoCity + ", 10.0.0.3, 10.0.0.2"}, ValidIPNoCity, lmw.Unknown},
+		{"10.0.0.1:9999", []string{"10.0.0.4, 10.0.0.3"}, "10.0.0.4", ""},
+		{"10.0.0.1:9999", []string{"[fe80::1%eth0]:80"}, "fe80::1", ""},
+		{"10.0.0.1:9999", []string{"fe80::1%eth0"}, "fe80::1", ""},
+		{ValidIP + ":9999", []string{ValidIPNoCity}, ValidIP, "Munich"},
+		{"192.168.1.2:9999", []string{ValidIP}, "192.168.1.2", ""},
+		// a hop that is not an IP can't be trusted to be the client, nor the spoofable hops left of it
+		{"10.0.0.1:9999", []string{ValidIP + ", qwerty, 10.0.0.2"}, "10.0.0.1", ""},
+		{"10.0.0.1:9999", []string{ValidIP + ", unknown"}, "10.0.0.1", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwardedFor {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.IPAddressHeader, test.expectedIP)
+		assertHeader(t, req, lmw.CityHeader, test.expectedCity)
+	}
+
+	mwCfg.PreferXForwardedForHeader = false
+	mwCfg.IPHeader = "X-Real-IP"
+	instance, _ = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "10.0.0.1:9999"
+	req.Header.Set("X-Real-IP", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = ValidIPNoCity + ":9999"
+	req.Header.Set("X-Real-IP", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIPNoCity)
+
+	mwCfg.TrustedProxies = []string{"10.0.0.0/33"}
+	if _, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail on invalid trusted proxy")
+	}
+}
+
+func TestGeoIPFromForwarded(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+	mwCfg.PreferForwardedHeader = true
+	mwCfg.PreferXForwardedForHeader = true
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, _ := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIPNoCity)
+	req.Header.Set("Forwarded", `for=_hidden, For="`+ValidIP+`:4711";proto=https, for=1.2.3.4`)
+	req.Header.Set("X-Forwarded-For", ValidIPNoCity)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIPNoCity)
+	req.Header.Set("Forwarded", "for=unknown;by=10.0.0.1")
+	req.Header.Set("X-Forwarded-For", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+
+	mwCfg.TrustedProxies = []string{"10.0.0.0/8", "2001:db8::/32"}
+	instance, _ = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "[2001:db8::1]:9999"
+	req.Header.Add("Forwarded", `for=1.2.3.4, for="`+ValidIP+`";proto=http`)
+	req.Header.Add("Forwarded", `for="[2001:db8:cafe::17]:4711"`)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+
+	// an unknown or obfuscated hop stops the walk like a hop that is not an IP, the ones left of it can be spoofed
+	tests := []struct {
+		forwarded  string
+		expectedIP string
+	}{
+		{"for=" + ValidIP + ", for=unknown", "10.0.0.1"},
+		{"for=" + ValidIP + ", for=_hidden", "10.0.0.1"},
+		{"for=" + ValidIP + ", for=_x, for=10.0.0.2", "10.0.0.1"},
+		{`for=_x, for="` + ValidIP + `", for=10.0.0.2`, ValidIP},
+		{"for=" + ValidIP + ";by=_x, for=10.0.0.2", ValidIP},
+	}
+	for _, test := range tests {
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "10.0.0.1:9999"
+		req.Header.Set("Forwarded", test.forwarded)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.IPAddressHeader, test.expectedIP)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = ValidIPNoCity + ":9999"
+	req.Header.Set("Forwarded", "for="+ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIPNoCity)
+}
+
+func TestGeoIPFromIPHeaders(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+	mwCfg.IPHeader = "CF-Connecting-IP"
+	mwCfg.IPHeaders = []string{"True-Client-IP", "x-real-ip", "Fastly-Client-IP"}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, _ := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	tests := []struct {
+		headers    map[string]string
+		expectedIP string
+	}{
+		{map[string]string{"CF-Connecting-IP": ValidIP, "X-Real-IP": ValidAlternateIP}, ValidIP},
+		{map[string]string{"CF-Connecting-IP": "", "True-Client-IP": "garbage", "X-Real-IP": ValidAlternateIP}, ValidAlternateIP},
+		{map[string]string{"Fastly-Client-IP": "[2001:db8::1]:443"}, "2001:db8::1"},
+		{map[string]string{"True-Client-IP": "unknown"}, ValidIPNoCity},
+		{map[string]string{}, ValidIPNoCity},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIPNoCity)
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.IPAddressHeader, test.expectedIP)
+	}
+}
+
+func TestGeoIPNetworkType(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+	mwCfg.AllowedCountries = []string{"DE"}
+	mwCfg.AllowedNetworkTypes = []string{"loopback", "Private"}
+	mwCfg.BlockedNetworkTypes = []string{"CGNAT", "reserved"}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr  string
+		networkType string
+		country     string
+		status      int
+	}{
+		{ValidIP + ":9999", "public", "DE", http.StatusOK},
+		{ValidIPNoCity + ":9999", "public", "US", http.StatusForbidden},
+		{"127.0.0.1:9999", "loopback", "", http.StatusOK},
+		{"[::1]:9999", "loopback", "", http.StatusOK},
+		{"10.1.2.3:9999", "private", "", http.StatusOK},
+		{"172.31.0.1:9999", "private", "", http.StatusOK},
+		{"192.168.0.10:9999", "private", "", http.StatusOK},
+		{"169.254.1.1:9999", "private", "", http.StatusOK},
+		{"[fd00::1]:9999", "private", "", http.StatusOK},
+		{"[::ffff:10.0.0.1]:9999", "private", "", http.StatusOK},
+		{"100.64.0.1:9999", "cgnat", "", http.StatusForbidden},
+		{"100.128.0.1:9999", "public", lmw.Unknown, http.StatusForbidden},
+		{"192.0.2.1:9999", "reserved", "", http.StatusForbidden},
+		{"[2001:db8::1]:9999", "reserved", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = test.remoteAddr
+		instance.ServeHTTP(recorder, req)
+		assertHeader(t, req, lmw.NetworkTypeHeader, test.networkType)
+		assertHeader(t, req, lmw.CountryCodeHeader, test.country)
+		if recorder.Result().StatusCode != test.status {
+			t.Fatalf("invalid return code for %s %d != %d", test.remoteAddr, recorder.Result().StatusCode, test.status)
+		}
+	}
+
+	mwCfg.BlockedNetworkTypes = []string{"intranet"}
+	if _, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail on unknown network type")
+	}
+	mwCfg.BlockedNetworkTypes = nil
+	mwCfg.AllowedNetworkTypes = []string{"intranet"}
+	if _, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail on unknown network type")
+	}
+}
+
+func TestGeoIPNetworkTypeUnknownCountry(t *testing.T) {
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	tests := []struct {
+		name    string
+		config  func(cfg *lmw.Config)
+		addr    string
+		xff     string
+		status  int
+		network string
+	}{
+		// an allowlist doesn't let unknown countries in, a private IP is one of them
+		{"allowlist", func(cfg *lmw.Config) { cfg.AllowedCountries = []string{"DE"} }, "10.1.2.3:9999", "", http.StatusForbidden, "private"},
+		{"allowlist spoofed XFF", func(cfg *lmw.Config) {
+			cfg.AllowedCountries = []string{"DE"}
+			cfg.PreferXForwardedForHeader = true
+		}, ValidIPNoCity + ":9999", "10.0.0.1", http.StatusForbidden, "private"},
+		{"allowlist allowed type", func(cfg *lmw.Config) {
+			cfg.AllowedCountries = []string{"DE"}
+			cfg.AllowedNetworkTypes = []string{"loopback"}
+		}, "127.0.0.1:9999", "", http.StatusOK, "loopback"},
+		{"unknown policy block", func(cfg *lmw.Config) {
+			cfg.BlockedCountries = []string{"US"}
+			cfg.UnknownCountryPolicy = lmw.PolicyBlock
+		}, "192.168.0.10:9999", "", http.StatusForbidden, "private"},
+		{"unknown policy block allowed type", func(cfg *lmw.Config) {
+			cfg.UnknownCountryPolicy = lmw.PolicyBlock
+			cfg.AllowedNetworkTypes = []string{"private"}
+		}, "192.168.0.10:9999", "", http.StatusOK, "private"},
+		{"unknown ASN policy block", func(cfg *lmw.Config) { cfg.UnknownAsnPolicy = lmw.PolicyBlock }, "100.64.0.1:9999", "", http.StatusForbidden, "cgnat"},
+		{"blocklist", func(cfg *lmw.Config) { cfg.BlockedCountries = []string{"US"} }, "10.1.2.3:9999", "", http.StatusOK, "private"},
+	}
+	for _, test := range tests {
+		mwCfg := mw.CreateConfig()
+		mwCfg.Fields = []string{"all"}
+		mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+		mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+		test.config(mwCfg)
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = test.addr
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+		instance.ServeHTTP(recorder, req)
+		assertHeader(t, req, lmw.NetworkTypeHeader, test.network)
+		if recorder.Result().StatusCode != test.status {
+			t.Fatalf("invalid return code for %s %d != %d", test.name, recorder.Result().StatusCode, test.status)
+		}
+	}
+}
+
+func TestGeoIPMaxDatabaseAge(t *testing.T) {
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	newConfig := func(maxDatabaseAge, policy string) *lmw.Config {
+		mwCfg := mw.CreateConfig()
+		mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+		mwCfg.MaxDatabaseAge = maxDatabaseAge
+		mwCfg.StaleDatabasePolicy = policy
+		mwCfg.DatabaseBuildDateHeader = true
+		return mwCfg
+	}
+	serve := func(instance http.Handler) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		return req
+	}
+
+	// the City fixture was built on 2024-10-30
+	instance, err := mw.New(context.TODO(), next, newConfig("1h", ""), "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req := serve(instance)
+	assertHeader(t, req, lmw.DatabaseBuildDateHeader, "2024-10-30")
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+
+	instance, err = mw.New(context.TODO(), next, newConfig("1h", "degrade"), "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req = serve(instance)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+	assertHeader(t, req, lmw.CityHeader, "")
+
+	if _, err = mw.New(context.TODO(), next, newConfig("1h", "fail"), "traefik-geoip"); !errors.Is(err, lmw.ErrStaleDatabase) {
+		t.Fatalf("Must fail on a stale DB: %v", err)
+	}
+
+	// the lookups of a stale DB are not used, their reload goroutines end
+	goroutines := runtime.NumGoroutine()
+	for _, policy := range []string{"degrade", "fail"} {
+		mwCfg := newConfig("1h", policy)
+		mwCfg.ReloadInterval = "1h"
+		_, _ = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runtime.NumGoroutine() > goroutines {
+		t.Fatalf("the lookups of a stale DB leak goroutines: %d > %d", runtime.NumGoroutine(), goroutines)
+	}
+
+	instance, err = mw.New(context.TODO(), next, newConfig("876000h", "fail"), "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req = serve(instance)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+
+	mwCfg := newConfig("1h", "")
+	mwCfg.DatabaseBuildDateHeader = false
+	instance, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req = serve(instance)
+	assertHeader(t, req, lmw.DatabaseBuildDateHeader, "")
+
+	for _, mwCfg := range []*lmw.Config{newConfig("1 month", ""), newConfig("-1h", ""), newConfig("1h", "ignore")} {
+		if _, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+			t.Fatalf("Must fail on invalid maxDatabaseAge=%q, staleDatabasePolicy=%q", mwCfg.MaxDatabaseAge, mwCfg.StaleDatabasePolicy)
+		}
+	}
+}
+
+func TestGeoIPFallbackDBs(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	mwCfg.CityDBPath = "data/mmdb/GeoIP2-Enterprise.mmdb"
+	mwCfg.CityDBPaths = []string{"data/mmdb/GeoLite2-City.mmdb", "data/mmdb/DBIP-City-Lite.mmdb"}
+	mwCfg.CountryDBPath = "data/mmdb/GeoLite2-Country.mmdb"
+	mwCfg.ISPDBPath = "data/mmdb/GeoIP2-ISP.mmdb"
+	mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+
+	tests := []struct {
+		ip        string
+		country   string
+		region    string
+		city      string
+		latitude  string
+		source    string
+		asn       string
+		asnSource string
+	}{
+		// the ASN DBs don't know the IP, the Enterprise ASN is kept
+		{"81.2.69.160", "GB", "ENG", "London", "51.5142", "GeoIP2-Enterprise", "20712", "GeoIP2-Enterprise"},
+		{ValidIP, "DE", "BY", "Munich", "48.1663", "GeoIP2-Enterprise", "3209", "GeoIP2-ISP"},
+		// the City fields GeoLite2 doesn't know come from DB-IP, the location is kept
+		{ValidIPNoCity, "US", lmw.Unknown, "Boydton", "37.751", "GeoLite2-City,DBIP-City-Lite", "8075", "GeoLite2-ASN"},
+		{"179.96.134.192", "BR", "SP", "Marília", "-22.2337", "GeoLite2-City", "26599", "GeoIP2-ISP"},
+		// only in the Country DB, no longer ignored when a City DB is set
+		{"188.194.0.1", "DE", lmw.Unknown, lmw.Unknown, lmw.Unknown, "GeoLite2-Country", lmw.Unknown, lmw.Unknown},
+		{"1.1.1.1", lmw.Unknown, lmw.Unknown, lmw.Unknown, lmw.Unknown, lmw.Unknown, lmw.Unknown, lmw.Unknown},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = test.ip + ":9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.CountryCodeHeader, test.country)
+		assertHeader(t, req, lmw.RegionCodeHeader, test.region)
+		assertHeader(t, req, lmw.CityHeader, test.city)
+		assertHeader(t, req, lmw.LatitudeHeader, test.latitude)
+		assertHeader(t, req, lmw.SourceHeader, test.source)
+		assertHeader(t, req, lmw.ASNSystemNumberHeader, test.asn)
+		assertHeader(t, req, lmw.ASNSourceHeader, test.asnSource)
+	}
+
+	// DB-IP knows the region, city and location, the time zone still comes from the next DB
+	mwCfg = mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	mwCfg.CityDBPaths = []string{"data/mmdb/DBIP-City-Lite.mmdb", "data/mmdb/GeoLite2-City.mmdb"}
+	instance, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = ValidIPNoCity + ":9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.RegionHeader, "Virginia")
+	assertHeader(t, req, lmw.LatitudeHeader, "36.6676")
+	assertHeader(t, req, lmw.TimeZoneHeader, "America/Chicago")
+	assertHeader(t, req, lmw.SourceHeader, "DBIP-City-Lite,GeoLite2-City")
+
+	// the time zone is not wanted, GeoLite2 is not looked up
+	mwCfg.Fields = []string{"region", "city", "latitude", "longitude", "source"}
+	instance, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = ValidIPNoCity + ":9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.RegionHeader, "Virginia")
+	assertHeader(t, req, lmw.SourceHeader, "DBIP-City-Lite")
+
+	// the first DB has no coordinates, decoded as 0, 0: the location comes from the next DB
+	data, err := os.ReadFile("data/mmdb/GeoLite2-City.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"\x48latitude", "\x49longitude", "\x4Faccuracy_radius"} {
+		data = bytes.ReplaceAll(data, []byte(key), []byte(key[:len(key)-1]+"X"))
+	}
+	mwCfg.CityDBPaths = []string{filepath.Join(t.TempDir(), "GeoLite2-City.mmdb"), "data/mmdb/DBIP-City-Lite.mmdb"}
+	if err = os.WriteFile(mwCfg.CityDBPaths[0], data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mwCfg.Fields = []string{"city", "latitude", "longitude", "accuracy_radius", "source"}
+	instance, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = ValidIPNoCity + ":9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Boydton")
+	assertHeader(t, req, lmw.LatitudeHeader, "36.6676")
+	assertHeader(t, req, lmw.SourceHeader, "GeoLite2-City,DBIP-City-Lite")
+}
+
+func TestGeoIPDatabases(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	// routed by type, the City DBs are kept in order
+	mwCfg.Databases = []string{
+		"data/mmdb/GeoIP2-Enterprise.mmdb", "data/mmdb/GeoIP2-ISP.mmdb", "data/mmdb/GeoLite2-City.mmdb",
+		"data/mmdb/GeoIP2-Anonymous-IP.mmdb", "data/mmdb/DBIP-City-Lite.mmdb", "data/mmdb/GeoIP2-Connection-Type.mmdb",
+		"data/mmdb/GeoLite2-Country.mmdb", "data/mmdb/GeoIP2-Domain.mmdb", "data/mmdb/GeoLite2-ASN.mmdb",
+	}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "179.96.134.192:9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Marília")
+	assertHeader(t, req, lmw.SourceHeader, "GeoLite2-City")
+	assertHeader(t, req, lmw.ASNSystemNumberHeader, "26599")
+	assertHeader(t, req, lmw.ASNSourceHeader, "GeoIP2-ISP")
+	assertHeader(t, req, lmw.ConnectionTypeHeader, "Cellular")
+	assertHeader(t, req, lmw.DomainHeader, "telesp.net.br")
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIPNoCity)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Boydton")
+	assertHeader(t, req, lmw.SourceHeader, "GeoLite2-City,DBIP-City-Lite")
+	assertHeader(t, req, lmw.ASNSourceHeader, "GeoLite2-ASN")
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "188.194.0.1:9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CountryCodeHeader, "DE")
+	assertHeader(t, req, lmw.SourceHeader, "GeoLite2-Country")
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "185.220.101.1:9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IsTorExitNodeHeader, "true")
+
+	// the type of a compressed DB is read from the decompressed file
+	mwCfg = mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	mwCfg.Databases = []string{filepath.Join(t.TempDir(), "GeoLite2-City.tar.gz")}
+	if err = os.WriteFile(mwCfg.Databases[0], tarGz(t, "GeoLite2-City_20241030/GeoLite2-City.mmdb", "data/mmdb/GeoLite2-City.mmdb"), 0o600); err != nil {
+		t.Fatalf("Error writing %v", err)
+	}
+	instance, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+
+	// not a DB, and a second ISP DB: no lookup
+	for _, databases := range [][]string{
+		{"data/mmdb/GeoLite2-City.mmdb", "README.md"},
+		{"data/mmdb/GeoIP2-ISP.mmdb", "data/mmdb/GeoLite2-City.mmdb", "data/mmdb/GeoIP2-ISP.mmdb"},
+	} {
+		mwCfg = mw.CreateConfig()
+		mwCfg.Fields = []string{"all"}
+		mwCfg.Databases = databases
+		instance, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.CityHeader, "")
+		assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+	}
+}
+
+func TestGeoIPDatabasesCompatTypes(t *testing.T) {
+	// the DB-IP types compatible with a MaxMind one are detected and read as the MaxMind type, the others are not
+	// detected rather than failing when the reader opens them
+	tests := []struct {
+		databaseType string
+		dbPath       string
+		ip           string
+		header       string
+		expected     string
+	}{
+		{"DBIP-Location (compat=City)", "data/mmdb/GeoLite2-City.mmdb", ValidIP, lmw.CityHeader, "Munich"},
+		{"DBIP-Location-ISP (compat=Enterprise)", "data/mmdb/GeoIP2-Enterprise.mmdb", "81.2.69.160", lmw.CityHeader, "London"},
+		{"DBIP-ASN-Lite (compat=GeoLite2-ASN)", "data/mmdb/GeoLite2-ASN.mmdb", ValidIP, lmw.ASNSystemNumberHeader, "3209"},
+		{"DBIP-ISP (compat=ISP)", "data/mmdb/GeoIP2-ISP.mmdb", "179.96.134.192", lmw.ISPHeader, "Vivo"},
+		{"DBIP-Country-Lite (compat=Country)", "data/mmdb/GeoLite2-Country.mmdb", ValidIP, lmw.CountryCodeHeader, ""},
+		{"DBIP-Location (compat=Enterprise-City)", "data/mmdb/GeoLite2-City.mmdb", ValidIP, lmw.CityHeader, ""},
+	}
+	for _, test := range tests {
+		data, err := os.ReadFile(test.dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the metadata is the last section, its database_type string can be resized
+		key := bytes.LastIndex(data, []byte("\x4Ddatabase_type")) + 14
+		size, control := int(data[key]&0x1f), 1
+		if size == 29 {
+			size, control = int(data[key+1])+29, 2
+		}
+		value := []byte{0x5D, byte(len(test.databaseType) - 29)}
+		if len(test.databaseType) < 29 {
+			value = []byte{0x40 | byte(len(test.databaseType))}
+		}
+		patched := append(append(append([]byte(nil), data[:key]...), value...), test.databaseType...)
+		patched = append(patched, data[key+control+size:]...)
+		dbPath := filepath.Join(t.TempDir(), "DBIP.mmdb")
+		if err = os.WriteFile(dbPath, patched, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		mwCfg := mw.CreateConfig()
+		mwCfg.Fields = []string{"all"}
+		mwCfg.Databases = []string{dbPath}
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = test.ip + ":9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, test.header, test.expected)
+	}
+}
+
+func TestGeoIPGenericDB(t *testing.T) {
+	for _, iso88591 := range []bool{false, true} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+		mwCfg.GenericDBs = []lmw.GenericDB{{
+			Path: "data/mmdb/Acme-Privacy.mmdb",
+			Headers: map[string]string{
+				"privacy.vpn":     "X-VPN",
+				"privacy.service": "X-VPN-Service",
+				"privacy.score":   "X-Privacy-Score",
+				"company.name":    "X-Company",
+				"company":         "X-Company-JSON",
+				"asn":             "X-ASN",
+				"tags":            "X-Tags",
+				"tags.1":          "X-Tag",
+				"abuse.email":     "X-Abuse",
+			},
+		}}
+		mwCfg.Iso88591 = iso88591
+
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, "X-VPN", "true")
+		assertHeader(t, req, "X-VPN-Service", "NordVPN")
+		assertHeader(t, req, "X-Privacy-Score", "0.75")
+		assertHeader(t, req, "X-Company", "Vodafone GmbH")
+		assertHeader(t, req, "X-Company-JSON", `{"domain":"vodafone.de","name":"Vodafone GmbH"}`)
+		assertHeader(t, req, "X-ASN", "3209")
+		assertHeader(t, req, "X-Tags", "isp,residential")
+		assertHeader(t, req, "X-Tag", "residential")
+		assertHeader(t, req, "X-Abuse", "abuse@vodafone.de,noc@vodafone.de")
+		assertHeader(t, req, lmw.CityHeader, "Munich")
+
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "179.96.134.192:9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, "X-VPN", "false")
+		assertHeader(t, req, "X-VPN-Service", lmw.Unknown)
+		assertHeader(t, req, "X-Tag", lmw.Unknown)
+		if iso88591 {
+			assertHeader(t, req, "X-Company", "Telef\xf4nica Brasil S.A")
+		} else {
+			assertHeader(t, req, "X-Company", "Telefônica Brasil S.A")
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIPNoCity)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, "X-VPN", lmw.Unknown)
+		assertHeader(t, req, "X-Company", lmw.Unknown)
+	}
+
+	for _, db := range []lmw.GenericDB{
+		{Path: "data/mmdb/Acme-Privacy.mmdb"},
+		{Path: "data/mmdb/Acme-Privacy.mmdb", Headers: map[string]string{"privacy..vpn": "X-VPN"}},
+		{Path: "data/mmdb/Acme-Privacy.mmdb", Headers: map[string]string{"privacy.vpn": " "}},
+	} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.GenericDBs = []lmw.GenericDB{db}
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		if _, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+			t.Fatalf("expected an error for genericDbs %v", db)
+		}
+	}
+}
+
+func TestGeoIPUnknownKeys(t *testing.T) {
+	// the records have keys of every type unknown to the readers, and the metadata one more key
+	data, err := os.ReadFile("data/mmdb/GeoIP2-City-Extended.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataStart := bytes.LastIndex(data, []byte("\xAB\xCD\xEFMaxMind.com")) + 14
+	if data[metadataStart] != 0xE9 {
+		t.Fatalf("unexpected metadata map: %x", data[metadataStart])
+	}
+	data[metadataStart] = 0xEA
+	data = append(data, "\x48x_vendor\x4312a"...)
+	dbPath := filepath.Join(t.TempDir(), "GeoIP2-City.mmdb")
+	if err = os.WriteFile(dbPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, iso88591 := range []bool{false, true} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.Fields = []string{"all"}
+		mwCfg.CityDBPath = dbPath
+		mwCfg.Iso88591 = iso88591
+
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.ContinentCodeHeader, "EU")
+		assertHeader(t, req, lmw.CountryCodeHeader, "DE")
+		assertHeader(t, req, lmw.RegionCodeHeader, "BY")
+		assertHeader(t, req, lmw.CityHeader, "Munich")
+		assertHeader(t, req, lmw.PostalCodeHeader, "80331")
+		assertHeader(t, req, lmw.LatitudeHeader, "48.1663")
+		assertHeader(t, req, lmw.TimeZoneHeader, "Europe/Berlin")
+	}
+}
+
+func TestGeoIPFourBytePointer(t *testing.T) {
+	// the city key of the record is replaced by a 4-byte pointer, whose size bits are part of the pointer, to a key
+	// appended to the data section
+	data, err := os.ReadFile("data/mmdb/GeoIP2-City-Extended.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dataStart, dataEnd := mmdbSections(data)
+	pointer := make([]byte, 5)
+	pointer[0] = 0x3F
+	binary.BigEndian.PutUint32(pointer[1:], uint32(dataEnd-dataStart))
+	section := bytes.ReplaceAll(data[dataStart:dataEnd], []byte("\x44city"), pointer)
+	if bytes.Equal(section, data[dataStart:dataEnd]) {
+		t.Fatalf("city key not found")
+	}
+	patched := append(append(append([]byte(nil), data[:dataStart]...), section...), "\x44city"...)
+	patched = append(patched, data[dataEnd:]...)
+	dbPath := filepath.Join(t.TempDir(), "GeoIP2-City.mmdb")
+	if err = os.WriteFile(dbPath, patched, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, iso88591 := range []bool{false, true} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.CityDBPath = dbPath
+		mwCfg.Iso88591 = iso88591
+
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.CountryCodeHeader, "DE")
+		assertHeader(t, req, lmw.CityHeader, "Munich")
+		assertHeader(t, req, lmw.PostalCodeHeader, "80331")
+	}
+}
+
+func TestGeoIPCyclicRecord(t *testing.T) {
+	// the record of ValidIP is replaced by a crafted one appended to the data section, the generic reader decodes
+	// any value so it must fail on each of them instead of looping, overflowing the stack or allocating gigabytes
+	data, err := os.ReadFile("data/mmdb/GeoIP2-City-Extended.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeCount, dataStart, dataEnd := mmdbSections(data)
+	offset := dataEnd - dataStart
+	pointer := []byte{0x38, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(pointer[1:], uint32(offset))
+
+	tests := []struct {
+		name   string
+		record []byte
+	}{
+		{"map holding a pointer to itself", append([]byte("\xE1\x44city"), pointer...)},
+		{"pointer to itself", pointer},
+		{"slice larger than the DB", []byte("\x1F\x04\xFF\xFF\xFF")},
+		{"uint16 of 16 bytes", append([]byte{0xB0}, make([]byte, 16)...)},
+	}
+	for _, test := range tests {
+		// the search tree record of ValidIP, 24 bits, points to the record appended after the data section
+		patched := append(append(append([]byte(nil), data[:dataEnd]...), test.record...), data[dataEnd:]...)
+		node, position := 0, 0
+		ip := append(make([]byte, 12), net.ParseIP(ValidIP).To4()...)
+		for i := 0; i < 128 && node < nodeCount; i++ {
+			position = node*6 + int(ip[i/8]>>(7-i%8)&1)*3
+			node = int(patched[position])<<16 | int(patched[position+1])<<8 | int(patched[position+2])
+		}
+		value := nodeCount + 16 + offset
+		patched[position], patched[position+1], patched[position+2] = byte(value>>16), byte(value>>8), byte(value)
+		dbPath := filepath.Join(t.TempDir(), "Crafted.mmdb")
+		if err = os.WriteFile(dbPath, patched, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, iso88591 := range []bool{false, true} {
+			lookup, err := lmw.NewLookupGeneric(dbPath, "traefik-geoip", iso88591)
+			if err != nil {
+				t.Fatalf("Error creating %v", err)
+			}
+			if _, err = lookup(net.ParseIP(ValidIP)); err == nil {
+				t.Fatalf("%s: expected an error, iso88591=%v", test.name, iso88591)
+			}
+		}
+	}
+}
+
+// mmdbSections returns the node count, and the start and end offsets of the data section of a DB with 24 bits records.
+func mmdbSections(data []byte) (int, int, int) {
+	dataEnd := bytes.LastIndex(data, []byte("\xAB\xCD\xEFMaxMind.com"))
+	nodeCount := 0
+	control := bytes.LastIndex(data, []byte("\x4Anode_count")) + 11
+	for _, b := range data[control+1 : control+1+int(data[control]&0x1f)] {
+		nodeCount = nodeCount<<8 | int(b)
+	}
+	// 24 bits records, followed by the 16 bytes data section separator
+	return nodeCount, nodeCount*6 + 16, dataEnd
+}
+
 func TestGeoIPCountryDBFromRemoteAddr(t *testing.T) {
 	mwCfg := mw.CreateConfig()
 	mwCfg.CountryDBPath = "data/mmdb/GeoLite2-Country.mmdb"
@@ -218,6 +1027,1126 @@ func TestGeoIpCityWithSpecialCharacters(t *testing.T) {
 	assertHeader(t, req, lmw.IPAddressHeader, "179.96.134.192")
 }
 
+func TestGeoIPCountryRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		dbPath   string
+		allowed  []string
+		blocked  []string
+		policy   string
+		ip       string
+		expected int
+	}{
+		{name: "allowed", dbPath: "data/mmdb/GeoLite2-City.mmdb", allowed: []string{"de"}, ip: ValidIP, expected: http.StatusOK},
+		{name: "not allowed", dbPath: "data/mmdb/GeoLite2-City.mmdb", allowed: []string{"BR"}, ip: ValidIP, expected: http.StatusForbidden},
+		{name: "blocked", dbPath: "data/mmdb/GeoLite2-City.mmdb", blocked: []string{"DE"}, ip: ValidIP, expected: http.StatusForbidden},
+		{name: "not blocked", dbPath: "data/mmdb/GeoLite2-City.mmdb", blocked: []string{"DE"}, ip: ValidIPNoCity, expected: http.StatusOK},
+		{name: "country db", dbPath: "data/mmdb/GeoLite2-Country.mmdb", blocked: []string{"DE"}, ip: ValidIP, expected: http.StatusForbidden},
+		{name: "unknown with allowlist", dbPath: "data/mmdb/GeoLite2-City.mmdb", allowed: []string{"DE"}, ip: "1.2.3.4", expected: http.StatusForbidden},
+		{name: "unknown allowed", dbPath: "data/mmdb/GeoLite2-City.mmdb", allowed: []string{"DE"}, policy: lmw.PolicyAllow, ip: "1.2.3.4", expected: http.StatusOK},
+		{name: "unknown with blocklist", dbPath: "data/mmdb/GeoLite2-City.mmdb", blocked: []string{"DE"}, ip: "1.2.3.4", expected: http.StatusOK},
+		{name: "unknown blocked", dbPath: "data/mmdb/GeoLite2-City.mmdb", policy: lmw.PolicyBlock, ip: "1.2.3.4", expected: http.StatusForbidden},
+		{name: "asn only", dbPath: "", allowed: []string{"DE"}, ip: ValidIP, expected: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mwCfg := mw.CreateConfig()
+			if strings.Contains(test.dbPath, "Country") {
+				mwCfg.CountryDBPath = test.dbPath
+			} else {
+				mwCfg.CityDBPath = test.dbPath
+			}
+			mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+			mwCfg.AllowedCountries = test.allowed
+			mwCfg.BlockedCountries = test.blocked
+			mwCfg.UnknownCountryPolicy = test.policy
+
+			called := false
+			next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) { called = true })
+			instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+			if err != nil {
+				t.Fatalf("Error creating %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.RemoteAddr = fmt.Sprintf("%s:9999", test.ip)
+			instance.ServeHTTP(recorder, req)
+			if recorder.Result().StatusCode != test.expected {
+				t.Fatalf("invalid return code %d != %d", recorder.Result().StatusCode, test.expected)
+			}
+			if called != (test.expected == http.StatusOK) {
+				t.Fatalf("next handler called: %v", called)
+			}
+		})
+	}
+}
+
+func TestGeoIPInvalidCountryPolicy(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.UnknownCountryPolicy = "maybe"
+	_, err := mw.New(context.TODO(), nil, mwCfg, "")
+	if err == nil {
+		t.Fatalf("Must fail on invalid unknownCountryPolicy")
+	}
+}
+
+func TestGeoIPAsnRules(t *testing.T) {
+	tests := []struct {
+		name           string
+		cityDBPath     string
+		countryDBPath  string
+		allowed        []string
+		blocked        []string
+		allowedOrgs    []string
+		blockedOrgs    []string
+		policy         string
+		ip             string
+		expected       int
+		expectedHeader string
+	}{
+		{name: "allowed", allowed: []string{"AS3209"}, ip: ValidIP, expected: http.StatusOK, expectedHeader: "3209"},
+		{name: "not allowed", allowed: []string{"8075"}, ip: ValidIP, expected: http.StatusForbidden},
+		{name: "blocked", blocked: []string{"8075"}, ip: ValidIPNoCity, expected: http.StatusForbidden},
+		{name: "not blocked", blocked: []string{"8075"}, ip: ValidIP, expected: http.StatusOK, expectedHeader: "3209"},
+		{name: "blocked organization", blockedOrgs: []string{"microsoft"}, ip: ValidIPNoCity, expected: http.StatusForbidden},
+		{name: "allowed organization", allowedOrgs: []string{"Vodafone"}, ip: ValidIP, expected: http.StatusOK, expectedHeader: "3209"},
+		{name: "not allowed organization", allowedOrgs: []string{"Vodafone"}, ip: ValidIPNoCity, expected: http.StatusForbidden},
+		{name: "unknown with allowlist", allowed: []string{"3209"}, ip: "1.2.3.4", expected: http.StatusForbidden},
+		{name: "unknown allowed", allowed: []string{"3209"}, policy: lmw.PolicyAllow, ip: "1.2.3.4", expected: http.StatusOK, expectedHeader: lmw.Unknown},
+		{name: "unknown blocked", policy: lmw.PolicyBlock, ip: "1.2.3.4", expected: http.StatusForbidden},
+		{name: "city", cityDBPath: "data/mmdb/GeoLite2-City.mmdb", blocked: []string{"3209"}, ip: ValidIP, expected: http.StatusForbidden},
+		{name: "country", countryDBPath: "data/mmdb/GeoLite2-Country.mmdb", blocked: []string{"3209"}, ip: ValidIP, expected: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mwCfg := mw.CreateConfig()
+			mwCfg.CityDBPath = test.cityDBPath
+			mwCfg.CountryDBPath = test.countryDBPath
+			mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+			mwCfg.AllowedAsns = test.allowed
+			mwCfg.BlockedAsns = test.blocked
+			mwCfg.AllowedAsnOrganizations = test.allowedOrgs
+			mwCfg.BlockedAsnOrganizations = test.blockedOrgs
+			mwCfg.UnknownAsnPolicy = test.policy
+
+			called := false
+			next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) { called = true })
+			instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+			if err != nil {
+				t.Fatalf("Error creating %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.RemoteAddr = fmt.Sprintf("%s:9999", test.ip)
+			instance.ServeHTTP(recorder, req)
+			if recorder.Result().StatusCode != test.expected {
+				t.Fatalf("invalid return code %d != %d", recorder.Result().StatusCode, test.expected)
+			}
+			if called != (test.expected == http.StatusOK) {
+				t.Fatalf("next handler called: %v", called)
+			}
+			if test.expectedHeader != "" {
+				assertHeader(t, req, lmw.ASNSystemNumberHeader, test.expectedHeader)
+			}
+		})
+	}
+}
+
+func TestGeoIPInvalidAsn(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.BlockedAsns = []string{"ASX"}
+	_, err := mw.New(context.TODO(), nil, mwCfg, "")
+	if err == nil {
+		t.Fatalf("Must fail on invalid ASN")
+	}
+}
+
+func TestGeoIPAnonymous(t *testing.T) {
+	for _, iso88591 := range []bool{false, true} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.Fields = []string{"all"}
+		mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+		mwCfg.AnonymousIPDBPath = "data/mmdb/GeoIP2-Anonymous-IP.mmdb"
+		mwCfg.Iso88591 = iso88591
+
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "185.220.101.1:9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.IsAnonymousHeader, "true")
+		assertHeader(t, req, lmw.IsAnonymousVPNHeader, "false")
+		assertHeader(t, req, lmw.IsPublicProxyHeader, "true")
+		assertHeader(t, req, lmw.IsTorExitNodeHeader, "true")
+		assertHeader(t, req, lmw.IPAddressHeader, "185.220.101.1")
+
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.IsAnonymousHeader, "false")
+		assertHeader(t, req, lmw.IsHostingProviderHeader, "false")
+		assertHeader(t, req, lmw.CityHeader, "Munich")
+	}
+}
+
+func TestGeoIPAnonymousRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      func(cfg *lmw.Config)
+		ip       string
+		expected int
+	}{
+		{name: "not blocked", cfg: func(_ *lmw.Config) {}, ip: "5.181.234.10", expected: http.StatusOK},
+		{name: "vpn", cfg: func(cfg *lmw.Config) { cfg.BlockAnonymousVPN = true }, ip: "5.181.234.10", expected: http.StatusForbidden},
+		{name: "tor", cfg: func(cfg *lmw.Config) { cfg.BlockTorExitNodes = true }, ip: "185.220.101.1", expected: http.StatusForbidden},
+		{name: "hosting", cfg: func(cfg *lmw.Config) { cfg.BlockHostingProviders = true }, ip: "45.83.220.10", expected: http.StatusForbidden},
+		{name: "residential proxy", cfg: func(cfg *lmw.Config) { cfg.BlockResidentialProxies = true }, ip: "89.160.20.112", expected: http.StatusForbidden},
+		{name: "public proxy", cfg: func(cfg *lmw.Config) { cfg.BlockPublicProxies = true }, ip: "5.181.234.10", expected: http.StatusOK},
+		{name: "anonymous", cfg: func(cfg *lmw.Config) { cfg.BlockAnonymous = true }, ip: "89.160.20.112", expected: http.StatusForbidden},
+		{name: "not anonymous", cfg: func(cfg *lmw.Config) { cfg.BlockAnonymous = true }, ip: ValidIP, expected: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mwCfg := mw.CreateConfig()
+			mwCfg.AnonymousIPDBPath = "data/mmdb/GeoIP2-Anonymous-IP.mmdb"
+			test.cfg(mwCfg)
+
+			next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+			instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+			if err != nil {
+				t.Fatalf("Error creating %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.RemoteAddr = fmt.Sprintf("%s:9999", test.ip)
+			instance.ServeHTTP(recorder, req)
+			if recorder.Result().StatusCode != test.expected {
+				t.Fatalf("invalid return code %d != %d", recorder.Result().StatusCode, test.expected)
+			}
+		})
+	}
+}
+
+func TestGeoIPIsp(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+	mwCfg.ISPDBPath = "data/mmdb/GeoIP2-ISP.mmdb"
+	mwCfg.BlockedAsns = []string{"3209"}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "179.96.134.192:9999"
+	instance.ServeHTTP(recorder, req)
+	assertHeader(t, req, lmw.ISPHeader, "Vivo")
+	assertHeader(t, req, lmw.OrganizationHeader, "Vivo")
+	assertHeader(t, req, lmw.MobileCountryCodeHeader, "724")
+	assertHeader(t, req, lmw.MobileNetworkCodeHeader, "10")
+	assertHeader(t, req, lmw.ASNSystemNumberHeader, "26599")
+	assertHeader(t, req, lmw.ASNOrganizationHeader, "TELEFÔNICA BRASIL S.A")
+	assertHeader(t, req, lmw.CityHeader, "Marília")
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(recorder, req)
+	assertHeader(t, req, lmw.ISPHeader, "Vodafone Kabel Deutschland")
+	assertHeader(t, req, lmw.MobileCountryCodeHeader, lmw.Unknown)
+	assertHeader(t, req, lmw.MobileNetworkCodeHeader, lmw.Unknown)
+	if recorder.Result().StatusCode != http.StatusForbidden {
+		t.Fatalf("ASN rules must use the ISP DB")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "1.2.3.4:9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.ISPHeader, lmw.Unknown)
+	assertHeader(t, req, lmw.ASNSystemNumberHeader, lmw.Unknown)
+}
+
+func TestGeoIPIspSingleLookup(t *testing.T) {
+	lookupIsp, err := lmw.NewLookupIsp("data/mmdb/GeoIP2-ISP.mmdb", "traefik-geoip", false)
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	lookupAsn, err := lmw.NewLookupAsn("data/mmdb/GeoLite2-ASN.mmdb", "traefik-geoip", false)
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	lookups := 0
+	countingIsp := func(ip net.IP) (*lmw.GeoIPIspResult, error) {
+		lookups++
+		return lookupIsp(ip)
+	}
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance := &lmw.TraefikGeoIPAsn{
+		Next:      next,
+		Name:      "traefik-geoip",
+		Options:   lmw.ConfigToOptions(&lmw.Config{Fields: []string{"all"}}),
+		LookupAsn: lmw.CreateFallbackAsnLookup([]lmw.LookupGeoIPAsn{lmw.CreateIspAsnLookup(countingIsp), lookupAsn}),
+	}
+
+	tests := []struct {
+		ip     string
+		isp    string
+		asn    string
+		source string
+	}{
+		{"179.96.134.192", "Vivo", "26599", "GeoIP2-ISP"},
+		// the ASN comes from the next DB, the ISP is unknown
+		{ValidIPNoCity, lmw.Unknown, "8075", "GeoLite2-ASN"},
+	}
+	for _, test := range tests {
+		lookups = 0
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = test.ip + ":9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.ISPHeader, test.isp)
+		assertHeader(t, req, lmw.ASNSystemNumberHeader, test.asn)
+		assertHeader(t, req, lmw.ASNSourceHeader, test.source)
+		if lookups != 1 {
+			t.Fatalf("invalid ISP DB lookups %d != 1", lookups)
+		}
+	}
+}
+
+func TestGeoIPConnectionTypeAndDomain(t *testing.T) {
+	for _, iso88591 := range []bool{false, true} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.Fields = []string{"all"}
+		mwCfg.ConnectionTypeDBPath = "data/mmdb/GeoIP2-Connection-Type.mmdb"
+		mwCfg.DomainDBPath = "data/mmdb/GeoIP2-Domain.mmdb"
+		mwCfg.Iso88591 = iso88591
+
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "179.96.134.192:9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.ConnectionTypeHeader, "Cellular")
+		assertHeader(t, req, lmw.DomainHeader, "telesp.net.br")
+
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIPNoCity)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.ConnectionTypeHeader, "Corporate")
+		assertHeader(t, req, lmw.DomainHeader, lmw.Unknown)
+	}
+}
+
+func TestGeoIPEnterprise(t *testing.T) {
+	for _, iso88591 := range []bool{false, true} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.Fields = []string{"all"}
+		mwCfg.CityDBPath = "data/mmdb/GeoIP2-Enterprise.mmdb"
+		mwCfg.Iso88591 = iso88591
+
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.CityHeader, "Munich")
+		assertHeader(t, req, lmw.CountryConfidenceHeader, "99")
+		assertHeader(t, req, lmw.RegionConfidenceHeader, "80")
+		assertHeader(t, req, lmw.CityConfidenceHeader, "60")
+		assertHeader(t, req, lmw.PostalConfidenceHeader, "20")
+		assertHeader(t, req, lmw.UserTypeHeader, "residential")
+		assertHeader(t, req, lmw.StaticIPScoreHeader, "13.5")
+		assertHeader(t, req, lmw.ISPHeader, "Vodafone Kabel Deutschland")
+		assertHeader(t, req, lmw.ConnectionTypeHeader, "Cable/DSL")
+		assertHeader(t, req, lmw.IsLegitimateProxyHeader, "false")
+		assertHeader(t, req, lmw.ASNSystemNumberHeader, "3209")
+
+		// the fields missing from the record are unknown
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "81.2.69.160:9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.DomainHeader, "aa.net.uk")
+		assertHeader(t, req, lmw.MobileCountryCodeHeader, lmw.Unknown)
+		assertHeader(t, req, lmw.MobileNetworkCodeHeader, lmw.Unknown)
+		assertHeader(t, req, lmw.ASNSystemNumberHeader, "20712")
+		assertHeader(t, req, lmw.ASNSourceHeader, "GeoIP2-Enterprise")
+	}
+
+	mwCfg := mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, _ := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityConfidenceHeader, "")
+}
+
+func TestGeoIPCityAllFields(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, _ := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.ContinentHeader, "Europe")
+	assertHeader(t, req, lmw.ContinentCodeHeader, "EU")
+	assertHeader(t, req, lmw.TimeZoneHeader, "Europe/Berlin")
+	assertHeader(t, req, lmw.MetroCodeHeader, lmw.Unknown)
+	assertHeader(t, req, lmw.IsInEuropeanUnionHeader, "true")
+	assertHeader(t, req, lmw.RegisteredCountryCodeHeader, "DE")
+	assertHeader(t, req, lmw.RegisteredCountryMismatchHeader, "false")
+	assertHeader(t, req, lmw.RepresentedCountryCodeHeader, lmw.Unknown)
+	assertHeader(t, req, lmw.SubdivisionCodesHeader, "BY")
+
+	mwCfg.CityDBPath = "data/mmdb/GeoIP2-Enterprise.mmdb"
+	instance, _ = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "81.2.69.160:9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CountryCodeHeader, "GB")
+	assertHeader(t, req, lmw.IsInEuropeanUnionHeader, "false")
+	assertHeader(t, req, lmw.MetroCodeHeader, "826")
+	assertHeader(t, req, lmw.RegisteredCountryHeader, "United States")
+	assertHeader(t, req, lmw.RegisteredCountryCodeHeader, "US")
+	assertHeader(t, req, lmw.RegisteredCountryMismatchHeader, "true")
+	assertHeader(t, req, lmw.RepresentedCountryCodeHeader, "US")
+	assertHeader(t, req, lmw.RegionCodeHeader, "ENG")
+	assertHeader(t, req, lmw.SubdivisionsHeader, "England,West Berkshire")
+	assertHeader(t, req, lmw.SubdivisionCodesHeader, "ENG,WBK")
+
+	mwCfg.CityDBPath = ""
+	mwCfg.CountryDBPath = "data/mmdb/GeoLite2-Country.mmdb"
+	instance, _ = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.ContinentCodeHeader, "EU")
+	assertHeader(t, req, lmw.RegisteredCountryCodeHeader, "DE")
+}
+
+func TestGeoIPFields(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+	mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+	mwCfg.Fields = []string{"country_code", " City ", "asn"}
+	mwCfg.BlockedCountries = []string{"BR"}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CountryCodeHeader, "DE")
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+	assertHeader(t, req, lmw.ASNSystemNumberHeader, "3209")
+	assertHeader(t, req, lmw.IPAddressHeader, "")
+	assertHeader(t, req, lmw.CountryHeader, "")
+	assertHeader(t, req, lmw.RegionCodeHeader, "")
+	assertHeader(t, req, lmw.LatitudeHeader, "")
+	assertHeader(t, req, lmw.ASNOrganizationHeader, "")
+
+	mwCfg.Fields = []string{"asn"}
+	instance, _ = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	recorder := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "179.96.134.192:9999"
+	instance.ServeHTTP(recorder, req)
+	assertHeader(t, req, lmw.CountryCodeHeader, "")
+	if recorder.Result().StatusCode != http.StatusForbidden {
+		t.Fatalf("country rules must apply to fields not sent")
+	}
+
+	mwCfg.Fields = nil
+	mwCfg.BlockedCountries = nil
+	instance, _ = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+	assertHeader(t, req, lmw.ASNOrganizationHeader, "Vodafone GmbH")
+	assertHeader(t, req, lmw.ContinentHeader, "")
+	assertHeader(t, req, lmw.TimeZoneHeader, "")
+
+	mwCfg.Fields = []string{"default", "time_zone"}
+	instance, _ = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+	assertHeader(t, req, lmw.TimeZoneHeader, "Europe/Berlin")
+	assertHeader(t, req, lmw.ContinentHeader, "")
+
+	mwCfg.Fields = []string{"country_code", "planet"}
+	if _, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail on unknown field")
+	}
+}
+
+func TestGeoIPLightMode(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.CountryDBPath = "data/mmdb/GeoLite2-Country.mmdb"
+	mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+	mwCfg.LightMode = true
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, _ := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+	assertHeader(t, req, lmw.CountryCodeHeader, "DE")
+	assertHeader(t, req, lmw.ASNSystemNumberHeader, "3209")
+	assertHeader(t, req, lmw.ASNOrganizationHeader, "Vodafone GmbH")
+	assertHeader(t, req, lmw.CountryHeader, "")
+	assertHeader(t, req, lmw.ContinentHeader, "")
+}
+
+func TestGeoIPLightModeCompatibility(t *testing.T) {
+	lookupCity, err := lmw.NewLookupCity("data/mmdb/GeoLite2-City.mmdb", "traefik-geoip", false)
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	lookupAsn, err := lmw.NewLookupAsn("data/mmdb/GeoLite2-ASN.mmdb", "traefik-geoip", false)
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	options := lmw.ConfigToOptions(&lmw.Config{})
+	for _, instance := range []http.Handler{
+		&lmw.TraefikGeoIPCityLightMode{Next: next, Name: "traefik-geoip", Options: options, LookupCity: lookupCity},
+		&lmw.TraefikGeoIPCityAsnLightMode{Next: next, Name: "traefik-geoip", Options: options, LookupAsn: lookupAsn, LookupCity: lookupCity},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.CountryCodeHeader, "DE")
+		assertHeader(t, req, lmw.CityHeader, "Munich")
+		assertHeader(t, req, lmw.CountryHeader, "")
+	}
+}
+
+func TestGeoIPHeaderNames(t *testing.T) {
+	mwCfg := mw.CreateConfig()
+	mwCfg.CountryDBPath = "data/mmdb/GeoLite2-Country.mmdb"
+	mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+	mwCfg.HeaderPrefix = "X-"
+	mwCfg.Headers = map[string]string{"country_code": "CF-IPCountry", "ip_address": "X-Real-IP"}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, "CF-IPCountry", "DE")
+	assertHeader(t, req, "X-Real-IP", ValidIP)
+	assertHeader(t, req, "X-Country", "Germany")
+	assertHeader(t, req, "X-ASN-System-Number", "3209")
+	assertHeader(t, req, lmw.CountryCodeHeader, "")
+	assertHeader(t, req, lmw.CountryHeader, "")
+
+	mwCfg.Headers = map[string]string{"planet": "X-Planet"}
+	if _, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail on unknown field")
+	}
+}
+
+func TestGeoIPLanguages(t *testing.T) {
+	for _, iso88591 := range []bool{false, true} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+		mwCfg.Languages = []string{"ja", " pt-BR", "en"}
+		mwCfg.LanguageHeaders = true
+		mwCfg.Iso88591 = iso88591
+
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "179.96.134.192:9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		if !iso88591 {
+			assertHeader(t, req, lmw.CountryHeader, "ブラジル連邦共和国")
+			assertHeader(t, req, lmw.CityHeader, "Marília")
+			assertHeader(t, req, lmw.RegionHeader+"-en", "São Paulo")
+		}
+		assertHeader(t, req, lmw.CityHeader+"-ja", lmw.Unknown)
+		assertHeader(t, req, lmw.CountryHeader+"-pt-BR", "Brasil")
+
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIPNoCity)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.CityHeader+"-pt-BR", lmw.Unknown)
+		assertHeader(t, req, lmw.RegionHeader+"-en", lmw.Unknown)
+	}
+
+	mwCfg := mw.CreateConfig()
+	mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+	mwCfg.Languages = []string{"xx", "pt-BR"}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, _ := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Munique")
+	assertHeader(t, req, lmw.RegionHeader, "Baviera")
+	assertHeader(t, req, lmw.CountryHeader, "Alemanha")
+	assertHeader(t, req, lmw.CityHeader+"-pt-BR", "")
+}
+
+func TestGeoIPOverrides(t *testing.T) {
+	yamlFile := filepath.Join(t.TempDir(), "overrides.yaml")
+	yamlOverrides := `# office networks
+overrides:
+  - network: 10.1.0.0/16
+    country: Brasil
+    countryCode: br
+    regionCode: SP
+    city: "Office #1"
+    asn: 64512
+    asnOrganization: ACME
+    labels:
+      X-Office: sao-paulo
+  - network: 10.0.0.0/8
+    countryCode: US
+`
+	if err := os.WriteFile(yamlFile, []byte(yamlOverrides), 0o600); err != nil {
+		t.Fatalf("Error writing %v", err)
+	}
+	jsonFile := filepath.Join(t.TempDir(), "overrides.json")
+	jsonOverrides := `[
+  {"network": "10.1.0.0/16", "country": "Brasil", "countryCode": "BR", "regionCode": "SP", "city": "Office #1",
+   "asn": 64512, "asnOrganization": "ACME", "labels": {"X-Office": "sao-paulo"}},
+  {"network": "10.0.0.0/8", "countryCode": "US"}
+]`
+	if err := os.WriteFile(jsonFile, []byte(jsonOverrides), 0o600); err != nil {
+		t.Fatalf("Error writing %v", err)
+	}
+
+	for _, overridesFile := range []string{yamlFile, jsonFile} {
+		mwCfg := mw.CreateConfig()
+		mwCfg.Fields = []string{"all"}
+		mwCfg.CityDBPath = "data/mmdb/GeoLite2-City.mmdb"
+		mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+		mwCfg.OverridesFile = overridesFile
+		mwCfg.Overrides = []lmw.Override{{Network: "188.193.88.0/24", City: "Munich HQ"}}
+		mwCfg.BlockedCountries = []string{"US"}
+
+		next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+		instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "10.1.2.3:9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.NetworkTypeHeader, "private")
+		assertHeader(t, req, lmw.CountryHeader, "Brasil")
+		assertHeader(t, req, lmw.CountryCodeHeader, "BR")
+		assertHeader(t, req, lmw.RegionCodeHeader, "SP")
+		assertHeader(t, req, lmw.CityHeader, "Office #1")
+		assertHeader(t, req, lmw.LatitudeHeader, lmw.Unknown)
+		assertHeader(t, req, lmw.ASNSystemNumberHeader, "64512")
+		assertHeader(t, req, lmw.ASNOrganizationHeader, "ACME")
+		assertHeader(t, req, "X-Office", "sao-paulo")
+
+		recorder := httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "10.2.0.1:9999"
+		instance.ServeHTTP(recorder, req)
+		assertHeader(t, req, lmw.CountryCodeHeader, "US")
+		assertHeader(t, req, lmw.CityHeader, lmw.Unknown)
+		assertHeader(t, req, "X-Office", "")
+		if recorder.Result().StatusCode != http.StatusForbidden {
+			t.Fatalf("overrides must go through the country rules")
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.CityHeader, "Munich HQ")
+		assertHeader(t, req, lmw.CountryCodeHeader, lmw.Unknown)
+		assertHeader(t, req, lmw.ASNSystemNumberHeader, "3209")
+
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "192.168.0.1:9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		assertHeader(t, req, lmw.CityHeader, "")
+	}
+
+	mwCfg := mw.CreateConfig()
+	mwCfg.Fields = []string{"all"}
+	mwCfg.Overrides = []lmw.Override{{Network: "10.1.0.0/16", CountryCode: "BR"}}
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, _ := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = "10.1.2.3:9999"
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CountryCodeHeader, "BR")
+	assertHeader(t, req, lmw.ASNSystemNumberHeader, "")
+
+	mwCfg.Overrides = []lmw.Override{{Network: "10.1.0.0/99", CountryCode: "BR"}}
+	if _, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail on invalid override network")
+	}
+	mwCfg.Overrides = nil
+	mwCfg.OverridesFile = filepath.Join(t.TempDir(), "missing.yaml")
+	if _, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail on missing overrides file")
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoIP2-City.mmdb")
+	copyFile(t, "data/mmdb/GeoLite2-City.mmdb", dbPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mwCfg := mw.CreateConfig()
+	mwCfg.CityDBPath = dbPath
+	mwCfg.ReloadInterval = "10ms"
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(ctx, next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	cityOf := func(ip string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = ip + ":9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		return req.Header.Get(lmw.CityHeader)
+	}
+	waitCity := func(ip, expected string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if cityOf(ip) == expected {
+				return
+			}
+		}
+		t.Fatalf("invalid value of header [%s] != %s", lmw.CityHeader, cityOf(ip))
+	}
+
+	if city := cityOf("81.2.69.160"); city != lmw.Unknown {
+		t.Fatalf("invalid value of header [%s] != %s", lmw.CityHeader, city)
+	}
+	copyFile(t, "data/mmdb/GeoIP2-Enterprise.mmdb", dbPath)
+	waitCity("81.2.69.160", "London")
+
+	// a corrupt DB keeps the last one loaded
+	if err = os.WriteFile(dbPath+".tmp", []byte("corrupt"), 0o600); err != nil {
+		t.Fatalf("Error writing %v", err)
+	}
+	if err = os.Rename(dbPath+".tmp", dbPath); err != nil {
+		t.Fatalf("Error renaming %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	waitCity("81.2.69.160", "London")
+
+	copyFile(t, "data/mmdb/GeoLite2-City.mmdb", dbPath)
+	waitCity("81.2.69.160", lmw.Unknown)
+	waitCity(ValidIP, "Munich")
+
+	mwCfg.ReloadInterval = "often"
+	if _, err = mw.New(ctx, next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail on invalid reload interval")
+	}
+}
+
+func TestGeoIPSharedDB(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoIP2-City.mmdb")
+	copyFile(t, "data/mmdb/GeoLite2-City.mmdb", dbPath)
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	newInstance := func(ctx context.Context, fields []string) http.Handler {
+		t.Helper()
+		mwCfg := mw.CreateConfig()
+		mwCfg.CityDBPath = dbPath
+		mwCfg.ReloadInterval = "10ms"
+		mwCfg.Fields = fields
+		instance, err := mw.New(ctx, next, mwCfg, "traefik-geoip")
+		if err != nil {
+			t.Fatalf("Error creating %v", err)
+		}
+		return instance
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	instance1 := newInstance(ctx1, nil)
+	instance2 := newInstance(ctx2, []string{"city"})
+
+	cityOf := func(instance http.Handler, ip string) (*http.Request, string) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = ip + ":9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		return req, req.Header.Get(lmw.CityHeader)
+	}
+	waitCity := func(instance http.Handler, ip, expected string) *http.Request {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if req, city := cityOf(instance, ip); city == expected {
+				return req
+			}
+		}
+		_, city := cityOf(instance, ip)
+		t.Fatalf("invalid value of header [%s] != %s", lmw.CityHeader, city)
+		return nil
+	}
+
+	// each instance keeps its own fields over the shared DB
+	req := waitCity(instance1, ValidIP, "Munich")
+	assertHeader(t, req, lmw.CountryCodeHeader, "DE")
+	req = waitCity(instance2, ValidIP, "Munich")
+	assertHeader(t, req, lmw.CountryCodeHeader, "")
+
+	copyFile(t, "data/mmdb/GeoIP2-Enterprise.mmdb", dbPath)
+	waitCity(instance1, "81.2.69.160", "London")
+	waitCity(instance2, "81.2.69.160", "London")
+
+	// a stopped instance releases the DB, the others keep reloading it
+	cancel1()
+	copyFile(t, "data/mmdb/GeoLite2-City.mmdb", dbPath)
+	waitCity(instance2, "81.2.69.160", lmw.Unknown)
+	instance3 := newInstance(ctx2, nil)
+	waitCity(instance3, ValidIP, "Munich")
+}
+
+func TestGeoIPMmap(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoIP2-City.mmdb")
+	copyFile(t, "data/mmdb/GeoLite2-City.mmdb", dbPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mwCfg := mw.CreateConfig()
+	mwCfg.CityDBPath = dbPath
+	mwCfg.AsnDBPath = "data/mmdb/GeoLite2-ASN.mmdb"
+	mwCfg.Mmap = true
+	mwCfg.Iso88591 = true
+	mwCfg.ReloadInterval = "10ms"
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(ctx, next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	serve := func(ip string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = ip + ":9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		return req
+	}
+
+	req := serve(ValidIP)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+	assertHeader(t, req, lmw.ASNSystemNumberHeader, "3209")
+
+	// the mapping of a replaced DB outlives the reload
+	copyFile(t, "data/mmdb/GeoIP2-Enterprise.mmdb", dbPath)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if serve("81.2.69.160").Header.Get(lmw.CityHeader) == "London" {
+			break
+		}
+	}
+	runtime.GC()
+	assertHeader(t, serve("81.2.69.160"), lmw.CityHeader, "London")
+}
+
+func TestGeoIPCompressedDB(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("data/mmdb/GeoLite2-City.mmdb")
+	if err != nil {
+		t.Fatalf("Error reading %v", err)
+	}
+	var gz bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gz)
+	_, _ = gzipWriter.Write(data)
+	_ = gzipWriter.Close()
+	// the extension doesn't matter, the format is detected by its magic bytes
+	files := map[string][]byte{
+		"GeoLite2-City.mmdb.gz": gz.Bytes(),
+		"GeoLite2-City.tar.gz":  tarGz(t, "GeoLite2-City_20241030/GeoLite2-City.mmdb", "data/mmdb/GeoLite2-City.mmdb"),
+		"geolite2.tgz": tarGz(t, "GeoLite2-ASN.mmdb", "data/mmdb/GeoLite2-ASN.mmdb",
+			"GeoLite2-Country.mmdb", "data/mmdb/GeoLite2-Country.mmdb", "GeoLite2-City.mmdb", "data/mmdb/GeoLite2-City.mmdb"),
+		"GeoLite2-City.mmdb": tarGz(t, "GeoLite2-City_20241030/GeoLite2-City.mmdb", "data/mmdb/GeoLite2-City.mmdb"),
+	}
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	for name, content := range files {
+		dbPath := filepath.Join(dir, name)
+		if err = os.WriteFile(dbPath, content, 0o600); err != nil {
+			t.Fatalf("Error writing %v", err)
+		}
+		for _, mmap := range []bool{false, true} {
+			mwCfg := mw.CreateConfig()
+			mwCfg.CityDBPath = dbPath
+			mwCfg.Mmap = mmap
+			instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+			if err != nil {
+				t.Fatalf("Error creating %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+			instance.ServeHTTP(httptest.NewRecorder(), req)
+			assertHeader(t, req, lmw.CityHeader, "Munich")
+		}
+	}
+
+	// every DB finds its member in the same archive
+	mwCfg := mw.CreateConfig()
+	mwCfg.CityDBPath = filepath.Join(dir, "geolite2.tgz")
+	mwCfg.AsnDBPath = filepath.Join(dir, "geolite2.tgz")
+	instance, err := mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+	assertHeader(t, req, lmw.ASNSystemNumberHeader, "3209")
+
+	mwCfg = mw.CreateConfig()
+	mwCfg.CityDBPath = filepath.Join(dir, "empty.tar.gz")
+	if err = os.WriteFile(mwCfg.CityDBPath, tarGz(t, "GeoLite2-City_20241030/README.txt", "README.md"), 0o600); err != nil {
+		t.Fatalf("Error writing %v", err)
+	}
+	instance, err = mw.New(context.TODO(), next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "")
+	assertHeader(t, req, lmw.IPAddressHeader, ValidIP)
+}
+
+func TestGeoIPMaxMindUpdater(t *testing.T) {
+	var mutex sync.Mutex
+	var archive []byte
+	checksum, downloads := "", 0
+	setArchive := func(name, src string) {
+		data := tarGz(t, name, src)
+		sum := sha256.Sum256(data)
+		mutex.Lock()
+		archive, checksum = data, hex.EncodeToString(sum[:])
+		mutex.Unlock()
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if user, key, ok := req.BasicAuth(); !ok || user != "42" || key != "secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Path != "/geoip/databases/GeoLite2-City/download" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch req.URL.Query().Get("suffix") {
+		case "tar.gz":
+			downloads++
+			_, _ = rw.Write(archive)
+		case "tar.gz.sha256":
+			_, _ = rw.Write([]byte(checksum + "  GeoLite2-City_20240101.tar.gz\n"))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	setArchive("GeoLite2-City_20240101/GeoLite2-City.mmdb", "data/mmdb/GeoLite2-City.mmdb")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mwCfg := mw.CreateConfig()
+	mwCfg.MaxMindAccountID = "42"
+	mwCfg.MaxMindLicenseKey = "secret"
+	mwCfg.MaxMindEditions = []string{"GeoLite2-City"}
+	mwCfg.MaxMindBaseURL = server.URL
+	mwCfg.MaxMindCacheDir = t.TempDir()
+
+	next := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	instance, err := mw.New(ctx, next, mwCfg, "traefik-geoip")
+	if err != nil {
+		t.Fatalf("Error creating %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+	if mwCfg.CityDBPath != "" {
+		t.Fatalf("the configuration must not be changed")
+	}
+
+	// an unchanged edition is not downloaded again
+	_, _ = mw.New(ctx, next, mwCfg, "traefik-geoip")
+	mutex.Lock()
+	if downloads != 1 {
+		t.Fatalf("invalid downloads %d != 1", downloads)
+	}
+	mutex.Unlock()
+
+	// the routers starting together wait for a single download of a missing edition
+	mwCfg.MaxMindCacheDir = t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, _ := mw.New(ctx, next, mwCfg, "traefik-geoip")
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+			instance.ServeHTTP(httptest.NewRecorder(), req)
+			if city := req.Header.Get(lmw.CityHeader); city != "Munich" {
+				t.Errorf("invalid value of header [%s] %s != Munich", lmw.CityHeader, city)
+			}
+		}()
+	}
+	wg.Wait()
+	mutex.Lock()
+	if downloads != 2 {
+		t.Fatalf("invalid downloads %d != 2", downloads)
+	}
+	// the cached edition is used when the download fails
+	validChecksum := checksum
+	checksum = "0000"
+	mutex.Unlock()
+	mwCfg.MaxMindCacheDir = t.TempDir()
+	copyFile(t, "data/mmdb/GeoLite2-City.mmdb", filepath.Join(mwCfg.MaxMindCacheDir, "GeoLite2-City.mmdb"))
+	instance, _ = mw.New(ctx, next, mwCfg, "traefik-geoip")
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+	mutex.Lock()
+	checksum = validChecksum
+	mutex.Unlock()
+
+	// a cached edition is updated in the background, a slow server doesn't delay the startup
+	unblock := make(chan struct{})
+	slowServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		<-unblock
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	slowCfg := *mwCfg
+	slowCfg.MaxMindBaseURL = slowServer.URL
+	slowCfg.MaxMindCacheDir = t.TempDir()
+	copyFile(t, "data/mmdb/GeoLite2-City.mmdb", filepath.Join(slowCfg.MaxMindCacheDir, "GeoLite2-City.mmdb"))
+	start := time.Now()
+	instance, _ = mw.New(ctx, next, &slowCfg, "traefik-geoip")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the startup waited for the update of a cached edition: %s", elapsed)
+	}
+	req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.RemoteAddr = fmt.Sprintf("%s:9999", ValidIP)
+	instance.ServeHTTP(httptest.NewRecorder(), req)
+	assertHeader(t, req, lmw.CityHeader, "Munich")
+	close(unblock)
+	slowServer.Close()
+
+	mwCfg.MaxMindUpdateInterval = "10ms"
+	mwCfg.ReloadInterval = "10ms"
+	instance, _ = mw.New(ctx, next, mwCfg, "traefik-geoip")
+	setArchive("GeoIP2-Enterprise_20240102/GeoIP2-Enterprise.mmdb", "data/mmdb/GeoIP2-Enterprise.mmdb")
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.RemoteAddr = "81.2.69.160:9999"
+		instance.ServeHTTP(httptest.NewRecorder(), req)
+		if req.Header.Get(lmw.CityHeader) == "London" {
+			break
+		}
+	}
+	assertHeader(t, req, lmw.CityHeader, "London")
+	if tmps, _ := filepath.Glob(filepath.Join(mwCfg.MaxMindCacheDir, "*.tmp")); len(tmps) > 0 {
+		t.Fatalf("the temporary files must be removed: %v", tmps)
+	}
+
+	mwCfg.MaxMindLicenseKey = ""
+	if _, err = mw.New(ctx, next, mwCfg, "traefik-geoip"); err == nil {
+		t.Fatalf("Must fail without license key")
+	}
+}
+
+// tarGz creates a tar.gz archive holding the file src named name.
+// tarGz archives the src files under their names, given in pairs, as MaxMind packs its editions.
+func tarGz(t *testing.T, nameAndSrc ...string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for i := 0; i+1 < len(nameAndSrc); i += 2 {
+		name, src := nameAndSrc[i], nameAndSrc[i+1]
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("Error reading %v", err)
+		}
+		_ = tarWriter.WriteHeader(&tar.Header{Name: filepath.Dir(name) + "/", Typeflag: tar.TypeDir, Mode: 0o755})
+		_ = tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data))})
+		_, _ = tarWriter.Write(data)
+	}
+	_ = tarWriter.Close()
+	_ = gzipWriter.Close()
+	return buffer.Bytes()
+}
+
+// copyFile replaces dst atomically, as geoipupdate does.
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatalf("Error reading %v", err)
+	}
+	if err = os.WriteFile(dst+".tmp", data, 0o600); err != nil {
+		t.Fatalf("Error writing %v", err)
+	}
+	if err = os.Rename(dst+".tmp", dst); err != nil {
+		t.Fatalf("Error renaming %v", err)
+	}
+}
+
 func assertHeader(t *testing.T, req *http.Request, key, expected string) {
 	t.Helper()
 	if req.Header.Get(key) != expected {